@@ -4,8 +4,13 @@ package claude
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
+	"reflect"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Client provides bidirectional communication with the Claude CLI.
@@ -46,7 +51,47 @@ type Client struct {
 	messages   chan Message
 	connected  bool
 	serverInfo map[string]any
+	info       *ServerInfo
 	mu         sync.RWMutex
+
+	// done is closed by closeDone when the session ends (reader exit or Close).
+	// err records why the session ended, if it ended abnormally.
+	done      chan struct{}
+	closeDone func()
+	err       error
+
+	// pending maps outgoing control request IDs to their response channels.
+	pending   map[string]chan *ControlResponsePayload
+	pendingMu sync.Mutex
+
+	// controlWaiting is signaled when a control request starts waiting for
+	// its response, so queued messages do not hold up reading it.
+	controlWaiting chan struct{}
+
+	// callbacks queues control requests for the callback workers.
+	callbacks chan map[string]any
+
+	// sendMu serializes writes to the transport.
+	sendMu sync.Mutex
+
+	// sessionCtx is cancelled by Close. turnCtx derives from it and is
+	// also cancelled by Interrupt; callbacks run under turnCtx.
+	sessionCtx    context.Context
+	sessionCancel context.CancelFunc
+	turnCtx       context.Context
+	turnCancel    context.CancelFunc
+
+	// stopSessionWatch stops closing the client when the context from
+	// WithSessionContext is done.
+	stopSessionWatch func() bool
+
+	// lastAPIError is the API error reported by the current turn's last
+	// assistant message. Only the message reader uses it.
+	lastAPIError *APIError
+
+	// lastTurn is the most recent Turn from Send; the next one waits for it.
+	lastTurn *Turn
+	turnMu   sync.Mutex
 }
 
 // NewClient creates a new Claude client with the given options.
@@ -63,6 +108,10 @@ func NewClient(opts ...Option) *Client {
 
 // Connect establishes a connection to the Claude CLI.
 // It must be called before Query or Messages.
+//
+// ctx bounds only the connection itself; cancelling it afterwards does not
+// end the session. Use Close, or WithSessionContext to tie the session to
+// a context.
 func (c *Client) Connect(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
@@ -71,7 +120,6 @@ func (c *Client) Connect(ctx context.Context) error {
 	}
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
 	// Use configured transport or create default subprocess transport
 	if c.cfg.transport != nil {
@@ -81,258 +129,770 @@ func (c *Client) Connect(ctx context.Context) error {
 	}
 
 	if err := c.transport.Connect(ctx); err != nil {
+		c.mu.Unlock()
 		return err
 	}
 
+	done := make(chan struct{})
+	c.done = done
+	c.closeDone = sync.OnceFunc(func() { close(done) })
+	c.pending = make(map[string]chan *ControlResponsePayload)
+	c.controlWaiting = make(chan struct{}, 1)
+	parent := c.cfg.sessionContext
+	if parent == nil {
+		parent = context.Background()
+	}
+	c.sessionCtx, c.sessionCancel = context.WithCancel(parent)
+	c.turnCtx, c.turnCancel = context.WithCancel(c.sessionCtx)
+	c.stopSessionWatch = context.AfterFunc(parent, func() { c.closeWithCause(parent) })
+
 	// Create message parsing goroutine
-	c.messages = make(chan Message, 100)
-	go c.readMessages()
+	c.messages = make(chan Message, c.cfg.messageBuffer())
+	c.callbacks = make(chan map[string]any, 100)
+	c.err = nil
+	c.info = nil
+	c.serverInfo = nil
+	go c.readMessages(done, c.closeDone)
 
 	c.connected = true
+	c.mu.Unlock()
 
-	// Send initialize request if hooks are configured
-	if len(c.cfg.hooks) > 0 {
-		if err := c.sendInitialize(ctx); err != nil {
-			c.connected = false
-			_ = c.transport.Close()
-			return err
+	// The lock is released first so the reader goroutine can deliver the
+	// initialize response.
+	if err := c.initialize(ctx); err != nil {
+		_ = c.Close()
+		return err
+	}
+
+	return nil
+}
+
+// initialize performs the initialize handshake, registering hooks with the
+// CLI and recording the server info from its response.
+func (c *Client) initialize(ctx context.Context) error {
+	timeout := c.cfg.initializeWait()
+	initCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := c.sendInitialize(initCtx)
+	if err != nil {
+		// Report why the CLI went away, such as a *ProcessError.
+		if sessionErr := c.Err(); sessionErr != nil {
+			return sessionErr
+		}
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: no initialize response within %v", ErrCLIConnection, timeout)
 		}
+		return err
 	}
 
+	result, _ := resp.Response.(map[string]any)
+
+	// The init system message may have arrived first, so the response is
+	// merged into the info it recorded.
+	c.mu.Lock()
+	if c.info == nil {
+		c.info = &ServerInfo{}
+	}
+	c.info.applyInitialize(result)
+	c.mu.Unlock()
 	return nil
 }
 
 // readMessages reads from transport and parses into Message types.
-func (c *Client) readMessages() {
+// closeDone is invoked once the transport stops delivering messages.
+// Delivery blocks until the consumer reads each message; after Close
+// (done is closed) undelivered messages are discarded.
+//
+// Control requests are handed to a pool of callback workers so slow hooks
+// do not stall message delivery. The messages channel is closed only after
+// every queued callback has been answered.
+func (c *Client) readMessages(done <-chan struct{}, closeDone func()) {
+	var workers sync.WaitGroup
+	for range c.cfg.callbackWorkers() {
+		workers.Go(c.runCallbacks)
+	}
+
 	defer close(c.messages)
+	defer workers.Wait()
+	defer close(c.callbacks)
+	defer closeDone()
+
+	parsed := make(chan Message)
+	go c.parseMessages(parsed)
+	c.deliverMessages(parsed, done)
+}
+
+// parseMessages parses each line from the transport, handling control
+// messages itself and sending the rest to out, which it closes once the
+// transport stops.
+func (c *Client) parseMessages(out chan<- Message) {
+	defer close(out)
 
 	for data := range c.transport.Messages() {
-		msg := c.parseMessage(data)
+		msg, err := c.parseMessage(data)
+		if err != nil {
+			if c.cfg.strictDecoding {
+				c.fail(err)
+				return
+			}
+			if c.cfg.messageErrorCallback != nil {
+				c.cfg.messageErrorCallback(err)
+			}
+		}
 		if msg != nil {
-			c.messages <- msg
+			out <- msg
 		}
 	}
+
+	c.collectTransportErrors()
 }
 
-// parseMessage converts raw JSON into a Message type.
-// Returns nil if the message cannot be parsed or if it was handled internally.
-func (c *Client) parseMessage(data []byte) Message {
-	var raw map[string]any
-	if err := json.Unmarshal(data, &raw); err != nil {
+// deliverMessages queues messages from in and sends them to the messages
+// channel until in is closed and the queue is empty, or done is closed.
+//
+// Once messageBuffer messages are queued it stops receiving, so a slow
+// consumer slows down the CLI, except while a control request waits for
+// its response: the response can arrive behind any number of messages,
+// and Interrupt or SetModel must not wait for the consumer, which may be
+// the caller itself.
+func (c *Client) deliverMessages(in <-chan Message, done <-chan struct{}) {
+	var queue []Message
+	for in != nil || len(queue) > 0 {
+		recv := in
+		if len(queue) >= c.cfg.messageBuffer() && !c.awaitingControlResponse() {
+			recv = nil
+		}
+		var send chan<- Message
+		var next Message
+		if len(queue) > 0 {
+			send, next = c.messages, queue[0]
+		}
+
+		select {
+		case msg, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+			queue = append(queue, msg)
+		case send <- next:
+			queue[0] = nil
+			queue = queue[1:]
+		case <-c.controlWaiting:
+			// Re-check whether to keep receiving past the limit.
+		case <-done:
+			// Let the parser finish, so control requests it has read are
+			// still handed to the callback workers.
+			if in != nil {
+				for range in {
+				}
+			}
+			return
+		}
+	}
+}
+
+// awaitingControlResponse reports whether a control request is waiting
+// for its response.
+func (c *Client) awaitingControlResponse() bool {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	return len(c.pending) > 0
+}
+
+// fail ends the session because of err, which Err then reports.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	go func() { _ = c.Close() }()
+}
+
+// collectTransportErrors records the first error the transport reported
+// before it stopped, such as a *ProcessError for a non-zero exit.
+func (c *Client) collectTransportErrors() {
+	errs := c.transport.Errors()
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.mu.Unlock()
+		default:
+			return
+		}
+	}
+}
+
+// DroppedMessages returns the number of stream events dropped because the
+// message buffer was full. It is always zero unless WithOverflowPolicy is
+// set to OverflowDropStreamEvents.
+func (c *Client) DroppedMessages() uint64 {
+	c.mu.RLock()
+	transport := c.transport
+	c.mu.RUnlock()
+
+	if counter, ok := transport.(interface{ DroppedMessages() uint64 }); ok {
+		return counter.DroppedMessages()
+	}
+	return 0
+}
+
+// Done returns a channel that is closed when the session ends, either
+// because the CLI exited or Close was called. Use Err to find out why.
+// Returns nil if the client has never been connected.
+func (c *Client) Done() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.done
+}
+
+// Err returns the error that ended the session, such as a *ProcessError
+// when the CLI exits with a non-zero status, or the context error when the
+// context from WithSessionContext is done. It returns nil while the
+// session is running and when the session ended normally or via Close.
+func (c *Client) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.err
+}
+
+// messageEnvelope holds the fields of a CLI message needed to route it,
+// plus those shared by several message types. Other message types are
+// decoded into their own structs once the type is known.
+type messageEnvelope struct {
+	Type            string  `json:"type"`
+	Subtype         string  `json:"subtype"`
+	UUID            string  `json:"uuid"`
+	SessionID       string  `json:"session_id"`
+	ParentToolUseID string  `json:"parent_tool_use_id"`
+	Error           string  `json:"error"`
+	Event           rawJSON `json:"event"`
+
+	// Message is decoded here rather than from a raw payload, so a large
+	// tool result is scanned only once.
+	Message *apiMessageIn `json:"message"`
+}
+
+// rawJSON is a JSON value that, unlike json.RawMessage, refers to the data
+// it was decoded from instead of copying it. Lines from the CLI are never
+// modified once read, and messages keep them as Raw anyway.
+type rawJSON []byte
+
+func (r *rawJSON) UnmarshalJSON(data []byte) error {
+	*r = data
+	return nil
+}
+
+// decodeJSON decodes data into v. Fields whose values have an unexpected
+// type are left unset rather than failing the whole message, since the
+// CLI's output is not versioned.
+func decodeJSON(data []byte, v any) error {
+	err := json.Unmarshal(data, v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
 		return nil
 	}
+	return err
+}
 
-	msgType, _ := raw["type"].(string)
+// parseMessage converts raw JSON into a Message type.
+// Returns nil if the message cannot be parsed or if it was handled internally.
+func (c *Client) parseMessage(data []byte) (Message, error) {
+	var env messageEnvelope
+	if err := decodeJSON(data, &env); err != nil {
+		return nil, &JSONDecodeError{Line: string(data), OriginalError: err}
+	}
 
-	switch msgType {
+	switch env.Type {
 	case "user":
-		return c.parseUserMessage(raw)
+		msg := parseUserMessage(&env, data)
+		msg.Raw = data
+		return msg, nil
 	case "assistant":
-		return c.parseAssistantMessage(raw)
+		msg := c.parseAssistantMessage(&env, data)
+		msg.Raw = data
+		return msg, nil
 	case "system":
-		return c.parseSystemMessage(raw)
+		return c.parseSystemMessage(data, env.Subtype), nil
 	case "result":
-		return c.parseResultMessage(raw)
+		msg := c.parseResultMessage(data)
+		msg.Raw = data
+		return msg, nil
 	case "stream_event":
-		return c.parseStreamEvent(raw)
-	case MessageTypeControlRequest:
-		c.handleControlRequest(raw)
-		return nil
+		msg := parseStreamEvent(&env)
+		msg.Raw = data
+		return msg, nil
+	case MessageTypeControlRequest, MessageTypeControlResponse:
+		var raw map[string]any
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, &JSONDecodeError{Line: string(data), OriginalError: err}
+		}
+		if env.Type == MessageTypeControlRequest {
+			c.callbacks <- raw
+		} else {
+			c.handleControlResponse(raw)
+		}
+		return nil, nil
 	default:
-		return nil
-	}
-}
-
-func (c *Client) parseUserMessage(raw map[string]any) *UserMessage {
-	msg := &UserMessage{}
-
-	if m, ok := raw["message"].(map[string]any); ok {
-		if content, ok := m["content"].(string); ok {
-			msg.Content = content
+		if c.cfg.strictDecoding {
+			return nil, &JSONDecodeError{
+				Line:          string(data),
+				OriginalError: fmt.Errorf("unknown message type %q", env.Type),
+			}
 		}
+		return &UnknownMessage{Type: env.Type, Raw: data}, nil
 	}
+}
 
-	if uuid, ok := raw["uuid"].(string); ok {
-		msg.UUID = uuid
+func parseUserMessage(env *messageEnvelope, data []byte) *UserMessage {
+	msg := &UserMessage{
+		UUID:            env.UUID,
+		ParentToolUseID: env.ParentToolUseID,
 	}
 
-	if parentID, ok := raw["parent_tool_use_id"].(string); ok {
-		msg.ParentToolUseID = parentID
+	if env.Message != nil {
+		msg.Content = env.Message.contentBlocks(data)
 	}
 
 	return msg
 }
 
-func (c *Client) parseAssistantMessage(raw map[string]any) *AssistantMessage {
+func (c *Client) parseAssistantMessage(env *messageEnvelope, data []byte) *AssistantMessage {
 	msg := &AssistantMessage{}
 
-	if m, ok := raw["message"].(map[string]any); ok {
-		if model, ok := m["model"].(string); ok {
-			msg.Model = model
-		}
-
-		if content, ok := m["content"].([]any); ok {
-			msg.Content = c.parseContentBlocks(content)
-		}
+	if env.Message != nil {
+		msg = env.Message.assistantMessage(data)
 	}
 
-	if parentID, ok := raw["parent_tool_use_id"].(string); ok {
-		msg.ParentToolUseID = parentID
-	}
+	msg.ParentToolUseID = env.ParentToolUseID
+	msg.Error = env.Error
 
-	if errStr, ok := raw["error"].(string); ok {
-		msg.Error = errStr
+	if msg.Error != "" && msg.ParentToolUseID == "" {
+		c.lastAPIError, _ = msg.Err().(*APIError)
 	}
 
 	return msg
 }
 
-func (c *Client) parseContentBlocks(content []any) []*ContentBlock {
-	blocks := make([]*ContentBlock, 0, len(content))
-
-	for _, item := range content {
-		block, ok := item.(map[string]any)
-		if !ok {
-			continue
-		}
-
-		blockType, _ := block["type"].(string)
-		switch blockType {
-		case "text":
-			text, _ := block["text"].(string)
-			blocks = append(blocks, NewTextBlock(text))
-
-		case "thinking":
-			thinking, _ := block["thinking"].(string)
-			signature, _ := block["signature"].(string)
-			blocks = append(blocks, NewThinkingBlock(thinking, signature))
+// apiMessageIn is an Anthropic API message being decoded. Its content is
+// decoded without custom unmarshalers, which would scan each block again.
+type apiMessageIn struct {
+	ID         string             `json:"id"`
+	Model      string             `json:"model"`
+	Content    []contentBlockJSON `json:"content"`
+	StopReason string             `json:"stop_reason"`
+	Usage      *Usage             `json:"usage"`
+}
 
-		case "tool_use":
-			id, _ := block["id"].(string)
-			name, _ := block["name"].(string)
-			input, _ := block["input"].(map[string]any)
-			blocks = append(blocks, NewToolUseBlock(id, name, input))
+// assistantMessage converts the message. parent is the JSON object that
+// holds it under "message"; see contentBlocks.
+func (m *apiMessageIn) assistantMessage(parent []byte) *AssistantMessage {
+	msg := &AssistantMessage{
+		Content:    m.contentBlocks(parent),
+		Model:      m.Model,
+		ID:         m.ID,
+		StopReason: m.StopReason,
+	}
+	if msg.Content == nil {
+		msg.Content = []*ContentBlock{}
+	}
+	if m.Usage != nil {
+		msg.Usage = *m.Usage
+	}
+	return msg
+}
 
-		case "tool_result":
-			toolUseID, _ := block["tool_use_id"].(string)
-			result := block["content"]
-			isError, _ := block["is_error"].(bool)
-			blocks = append(blocks, NewToolResultBlock(toolUseID, result, isError))
+// contentBlocks converts the message's content, skipping entries that are
+// not blocks. parent is the JSON object that holds the message under
+// "message". It is decoded again only for what the typed content lacks:
+// string content, which becomes a text block, and the original JSON of
+// blocks of unknown type.
+func (m *apiMessageIn) contentBlocks(parent []byte) []*ContentBlock {
+	var raw struct {
+		Message struct {
+			Content rawJSON `json:"content"`
+		} `json:"message"`
+	}
+	if m.Content == nil {
+		_ = decodeJSON(parent, &raw)
+		var text string
+		if json.Unmarshal(raw.Message.Content, &text) == nil {
+			return []*ContentBlock{NewTextBlock(text)}
 		}
+		return nil
 	}
 
+	blocks := make([]*ContentBlock, 0, len(m.Content))
+	var items []rawJSON
+	for i := range m.Content {
+		in := &m.Content[i]
+		if block, ok := in.block(); ok {
+			blocks = append(blocks, block)
+			continue
+		}
+		if in.Type == "" {
+			continue
+		}
+		if items == nil {
+			_ = decodeJSON(parent, &raw)
+			_ = decodeJSON(raw.Message.Content, &items)
+		}
+		if i < len(items) {
+			blocks = append(blocks, &ContentBlock{Kind: BlockUnknown, Raw: json.RawMessage(items[i])})
+		}
+	}
 	return blocks
 }
 
-func (c *Client) parseSystemMessage(raw map[string]any) *SystemMessage {
-	msg := &SystemMessage{
-		Data: make(map[string]any),
+// parseSystemMessage returns a typed message for known subtypes and a
+// *SystemMessage for the rest.
+func (c *Client) parseSystemMessage(data []byte, subtype string) Message {
+	switch subtype {
+	case SystemSubtypeInit:
+		msg := &SystemInitMessage{Raw: data}
+		if err := decodeJSON(data, msg); err == nil {
+			msg.Extra = extraFields(data, msg)
+			c.recordInit(msg, data)
+			return msg
+		}
+	case SystemSubtypeCompactBoundary:
+		msg := &SystemCompactBoundaryMessage{Raw: data}
+		if err := decodeJSON(data, msg); err == nil {
+			msg.Extra = extraFields(data, msg)
+			return msg
+		}
 	}
 
-	if subtype, ok := raw["subtype"].(string); ok {
-		msg.Subtype = subtype
-	}
+	var raw map[string]any
+	_ = json.Unmarshal(data, &raw)
 
+	msg := &SystemMessage{
+		Subtype: subtype,
+		Data:    make(map[string]any),
+		Raw:     data,
+	}
 	if data, ok := raw["data"].(map[string]any); ok {
 		msg.Data = data
-
-		if msg.Subtype == "init" {
-			c.mu.Lock()
-			c.serverInfo = data
-			c.mu.Unlock()
+	} else {
+		for key, value := range raw {
+			if key != "type" && key != "subtype" {
+				msg.Data[key] = value
+			}
 		}
 	}
 
 	return msg
 }
 
-func (c *Client) parseResultMessage(raw map[string]any) *ResultMessage {
-	msg := &ResultMessage{}
-
-	if subtype, ok := raw["subtype"].(string); ok {
-		msg.Subtype = subtype
+// recordInit updates the server info from the init system message.
+func (c *Client) recordInit(msg *SystemInitMessage, data []byte) {
+	var raw struct {
+		Data map[string]any `json:"data"`
 	}
+	_ = decodeJSON(data, &raw)
 
-	if duration, ok := raw["duration_ms"].(float64); ok {
-		msg.DurationMS = int(duration)
-	}
-
-	if duration, ok := raw["duration_api_ms"].(float64); ok {
-		msg.DurationAPIMS = int(duration)
-	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if isError, ok := raw["is_error"].(bool); ok {
-		msg.IsError = isError
+	if raw.Data != nil {
+		c.serverInfo = raw.Data
 	}
-
-	if numTurns, ok := raw["num_turns"].(float64); ok {
-		msg.NumTurns = int(numTurns)
+	if c.info == nil {
+		c.info = &ServerInfo{}
 	}
+	c.info.applyInit(msg)
+}
 
-	if sessionID, ok := raw["session_id"].(string); ok {
-		msg.SessionID = sessionID
+// extraFields returns the top-level fields of data that have no
+// corresponding json tag in the struct v points to, ignoring type and
+// subtype. Returns nil if there are none.
+func extraFields(data []byte, v any) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
 	}
 
-	if cost, ok := raw["total_cost_usd"].(float64); ok {
-		msg.TotalCostUSD = cost
+	delete(fields, "type")
+	delete(fields, "subtype")
+	t := reflect.TypeOf(v).Elem()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		delete(fields, name)
 	}
 
-	if usage, ok := raw["usage"].(map[string]any); ok {
-		msg.Usage = usage
+	if len(fields) == 0 {
+		return nil
 	}
+	return fields
+}
 
-	if result, ok := raw["result"].(string); ok {
-		msg.Result = result
-	}
+func (c *Client) parseResultMessage(data []byte) *ResultMessage {
+	msg := &ResultMessage{}
+	_ = decodeJSON(data, msg)
 
-	if output, ok := raw["structured_output"]; ok {
-		msg.StructuredOutput = output
+	// The result only says that the turn failed; the assistant message
+	// before it says why.
+	if msg.IsError {
+		msg.apiError = c.lastAPIError
 	}
+	c.lastAPIError = nil
 
 	return msg
 }
 
-func (c *Client) parseStreamEvent(raw map[string]any) *StreamEvent {
-	event := &StreamEvent{}
+// streamEventJSON is an Anthropic API stream event.
+type streamEventJSON struct {
+	Type         StreamEventType `json:"type"`
+	Index        int             `json:"index"`
+	Message      *apiMessageIn   `json:"message"`
+	ContentBlock *ContentBlock   `json:"content_block"`
+	Usage        *Usage          `json:"usage"`
+
+	// Delta is a content block delta, or a message delta with a stop
+	// reason.
+	Delta *struct {
+		Type        DeltaType `json:"type"`
+		Text        string    `json:"text"`
+		Thinking    string    `json:"thinking"`
+		PartialJSON string    `json:"partial_json"`
+		Signature   string    `json:"signature"`
+		StopReason  string    `json:"stop_reason"`
+	} `json:"delta"`
+}
 
-	if uuid, ok := raw["uuid"].(string); ok {
-		event.UUID = uuid
-	}
-	if sessionID, ok := raw["session_id"].(string); ok {
-		event.SessionID = sessionID
-	}
-	if e, ok := raw["event"].(map[string]any); ok {
-		event.Event = e
-	}
-	if parentID, ok := raw["parent_tool_use_id"].(string); ok {
-		event.ParentToolUseID = parentID
+func parseStreamEvent(env *messageEnvelope) *StreamEvent {
+	event := &StreamEvent{
+		UUID:            env.UUID,
+		SessionID:       env.SessionID,
+		Event:           json.RawMessage(env.Event),
+		ParentToolUseID: env.ParentToolUseID,
 	}
 
+	decodeStreamEvent(event)
+
 	return event
 }
 
+// decodeStreamEvent fills in the typed fields of a stream event from
+// its raw API event.
+func decodeStreamEvent(event *StreamEvent) {
+	var e streamEventJSON
+	if decodeJSON(event.Event, &e) != nil {
+		return
+	}
+	event.Type = e.Type
+	event.Index = e.Index
+
+	switch event.Type {
+	case StreamMessageStart:
+		if e.Message != nil {
+			event.Message = e.Message.assistantMessage(event.Event)
+		}
+
+	case StreamContentBlockStart:
+		event.ContentBlock = e.ContentBlock
+
+	case StreamContentBlockDelta:
+		event.Delta = &StreamDelta{}
+		if d := e.Delta; d != nil {
+			*event.Delta = StreamDelta{
+				Type:        d.Type,
+				Text:        d.Text,
+				Thinking:    d.Thinking,
+				PartialJSON: d.PartialJSON,
+				Signature:   d.Signature,
+			}
+		}
+
+	case StreamMessageDelta:
+		if e.Delta != nil {
+			event.StopReason = e.Delta.StopReason
+		}
+		event.Usage = e.Usage
+
+	case StreamContentBlockStop, StreamMessageStop:
+		// These events carry nothing beyond their type and index.
+	}
+}
+
 // Close disconnects from the Claude CLI and releases resources.
-// It is safe to call Close multiple times.
+// With the default transport it waits for the CLI to exit (see
+// WithShutdownGracePeriod) and returns a *ProcessError if it did not
+// exit cleanly. It is safe to call Close multiple times.
 func (c *Client) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if !c.connected {
+		c.mu.Unlock()
 		return nil
 	}
 
 	c.connected = false
+	c.stopSessionWatch()
+	c.closeDone()
+	c.sessionCancel()
+	transport := c.transport
+	c.mu.Unlock()
 
-	if c.transport != nil {
-		return c.transport.Close()
+	// The transport may take a while to shut down, and callbacks it runs
+	// meanwhile, such as the stderr callback, may call into the client.
+	if transport != nil {
+		return transport.Close()
 	}
 
 	return nil
 }
 
+// closeWithCause closes the client because the session context is done.
+func (c *Client) closeWithCause(parent context.Context) {
+	c.closeWithError(context.Cause(parent))
+}
+
+// closeWithError closes the client, recording err as the reason the
+// session ended.
+func (c *Client) closeWithError(err error) {
+	c.mu.Lock()
+	if c.connected && c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	_ = c.Close()
+}
+
 // Query sends a prompt to Claude.
 // Connect must be called before Query.
 func (c *Client) Query(ctx context.Context, prompt string) error {
+	return c.sendUserMessage(ctx, prompt)
+}
+
+// QueryContent sends a prompt made of several blocks, such as text with
+// images or PDFs. Connect must be called before QueryContent.
+//
+// Example:
+//
+//	err := client.QueryContent(ctx,
+//	    claude.TextInput("What is wrong with this layout?"),
+//	    claude.FileInput("screenshot.png"),
+//	)
+func (c *Client) QueryContent(ctx context.Context, blocks ...InputBlock) error {
+	content := make([]*ContentBlock, 0, len(blocks))
+	for _, input := range blocks {
+		block, err := input.resolve()
+		if err != nil {
+			return err
+		}
+		content = append(content, block)
+	}
+	return c.sendUserMessage(ctx, content)
+}
+
+// Turn sends a prompt and returns an iterator over the response. It
+// yields each message up to and including the turn's ResultMessage. If
+// the session ends first, the last pair carries the error that ended it,
+// or ErrNoResult. Connect must be called before Turn.
+//
+// If the loop exits before the ResultMessage, Turn interrupts the turn
+// and discards its remaining messages, so the client is ready for the
+// next prompt.
+//
+// Example:
+//
+//	for msg, err := range client.Turn(ctx, "What files are here?") {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    fmt.Printf("%T\n", msg)
+//	}
+func (c *Client) Turn(ctx context.Context, prompt string) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		if err := c.Query(ctx, prompt); err != nil {
+			yield(nil, err)
+			return
+		}
+		if c.receiveTurn(ctx, yield) {
+			_, _ = c.abandonTurn(ctx)
+		}
+	}
+}
+
+// receiveTurn yields messages until the ResultMessage, then returns
+// false. If the session ends first it yields the error. It returns true
+// if yield stopped the loop before the ResultMessage.
+func (c *Client) receiveTurn(ctx context.Context, yield func(Message, error) bool) bool {
+	msgs := c.Messages()
+	if msgs == nil {
+		yield(nil, ErrNotConnected)
+		return false
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			yield(nil, ctx.Err())
+			return false
+		case msg, ok := <-msgs:
+			if !ok {
+				yield(nil, c.resultErr())
+				return false
+			}
+			_, isResult := msg.(*ResultMessage)
+			if !yield(msg, nil) {
+				return !isResult
+			}
+			if isResult {
+				return false
+			}
+		}
+	}
+}
+
+// abandonTurn interrupts the current turn and discards its messages up
+// to the ResultMessage, which it returns. If the turn has not ended
+// within the shutdown grace period, the session is closed, since the
+// rest of the turn would otherwise be read as the next one, and the
+// error it was closed with is returned.
+func (c *Client) abandonTurn(ctx context.Context) (*ResultMessage, error) {
+	grace := c.cfg.shutdownGrace()
+	timeout := fmt.Errorf("%w: interrupted turn did not end within %v", ErrCLIConnection, grace)
+	ctx, cancel := context.WithTimeoutCause(ctx, grace, timeout)
+	defer cancel()
+
+	_ = c.Interrupt(ctx)
+
+	var result *ResultMessage
+	c.receiveTurn(ctx, func(msg Message, _ error) bool {
+		result, _ = msg.(*ResultMessage)
+		return true
+	})
+	if result == nil && context.Cause(ctx) == timeout {
+		c.closeWithError(timeout)
+		return nil, timeout
+	}
+	return result, nil
+}
+
+// resultErr returns why the session ended before a result: the error
+// that ended it, or ErrNoResult.
+func (c *Client) resultErr() error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return ErrNoResult
+}
+
+// sendUserMessage writes a user message with the given content, either a
+// string or a list of content blocks.
+func (c *Client) sendUserMessage(ctx context.Context, content any) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -351,7 +911,7 @@ func (c *Client) Query(ctx context.Context, prompt string) error {
 		"type": "user",
 		"message": map[string]any{
 			"role":    "user",
-			"content": prompt,
+			"content": content,
 		},
 		"parent_tool_use_id": nil,
 		"session_id":         "default",
@@ -365,6 +925,14 @@ func (c *Client) Query(ctx context.Context, prompt string) error {
 	// Append newline for JSONL format
 	data = append(data, '\n')
 
+	return c.send(ctx, transport, data)
+}
+
+// send writes data to the transport, serializing concurrent writers so
+// messages from callbacks and callers never interleave.
+func (c *Client) send(ctx context.Context, transport Transport, data []byte) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
 	return transport.Send(ctx, data)
 }
 
@@ -390,97 +958,81 @@ func (c *Client) IsConnected() bool {
 
 // Interrupt sends an interrupt signal to stop the current operation.
 // This is only effective during an active query.
+// It blocks until the CLI acknowledges the request or ctx is done.
+//
+// Contexts passed to in-flight hook and permission callbacks are cancelled.
 func (c *Client) Interrupt(ctx context.Context) error {
-	c.mu.RLock()
-	if !c.connected {
-		c.mu.RUnlock()
-		return ErrNotConnected
-	}
-	transport := c.transport
-	c.mu.RUnlock()
+	c.cancelTurn()
+	_, err := c.sendControlRequest(ctx, NewInterruptRequest())
+	return err
+}
 
-	req := &ControlRequest{
-		Type:      MessageTypeControlRequest,
-		RequestID: generateRequestID(),
-		Request: &ControlRequestBody{
-			Subtype: ControlSubtypeInterrupt,
-		},
-	}
+// cancelTurn cancels callbacks running for the current turn and starts a
+// fresh turn context for later callbacks.
+func (c *Client) cancelTurn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	data, err := json.Marshal(req)
-	if err != nil {
-		return err
+	if !c.connected {
+		return
 	}
-	data = append(data, '\n')
 
-	return transport.Send(ctx, data)
+	c.turnCancel()
+	c.turnCtx, c.turnCancel = context.WithCancel(c.sessionCtx)
 }
 
-// SetPermissionMode changes the permission mode during a conversation.
-// Valid modes: "default", "acceptEdits", "plan", "bypassPermissions".
-func (c *Client) SetPermissionMode(ctx context.Context, mode PermissionMode) error {
+// callbackContext returns the context for a hook or permission callback.
+// It is cancelled when the client closes or the turn is interrupted,
+// and carries a deadline when timeout is positive.
+func (c *Client) callbackContext(timeout time.Duration) (context.Context, context.CancelFunc) {
 	c.mu.RLock()
-	if !c.connected {
-		c.mu.RUnlock()
-		return ErrNotConnected
-	}
-	transport := c.transport
+	parent := c.turnCtx
 	c.mu.RUnlock()
 
-	req := &ControlRequest{
-		Type:      MessageTypeControlRequest,
-		RequestID: generateRequestID(),
-		Request: &ControlRequestBody{
-			Subtype: ControlSubtypeSetPermissionMode,
-			Mode:    string(mode),
-		},
+	if parent == nil {
+		parent = context.Background()
 	}
-
-	data, err := json.Marshal(req)
-	if err != nil {
-		return err
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
 	}
-	data = append(data, '\n')
+	return context.WithCancel(parent)
+}
 
-	return transport.Send(ctx, data)
+// SetPermissionMode changes the permission mode during a conversation.
+// Valid modes: "default", "acceptEdits", "plan", "bypassPermissions".
+// It blocks until the CLI acknowledges the request or ctx is done.
+func (c *Client) SetPermissionMode(ctx context.Context, mode PermissionMode) error {
+	_, err := c.sendControlRequest(ctx, NewSetPermissionModeRequest(mode))
+	return err
 }
 
 // SetModel changes the AI model during a conversation.
 // Pass empty string to use the default model.
+// It blocks until the CLI acknowledges the request or ctx is done.
 func (c *Client) SetModel(ctx context.Context, model string) error {
-	c.mu.RLock()
-	if !c.connected {
-		c.mu.RUnlock()
-		return ErrNotConnected
-	}
-	transport := c.transport
-	c.mu.RUnlock()
-
-	var modelPtr *string
-	if model != "" {
-		modelPtr = &model
-	}
+	_, err := c.sendControlRequest(ctx, NewSetModelRequest(model))
+	return err
+}
 
-	req := &ControlRequest{
-		Type:      MessageTypeControlRequest,
-		RequestID: generateRequestID(),
-		Request: &ControlRequestBody{
-			Subtype: ControlSubtypeSetModel,
-			Model:   modelPtr,
-		},
-	}
+// ServerInfo returns information about the connected CLI session, such
+// as its commands, tools, MCP server statuses and model. It returns nil
+// before Connect. See ServerInfo for when each field becomes available.
+func (c *Client) ServerInfo() *ServerInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	data, err := json.Marshal(req)
-	if err != nil {
-		return err
+	if c.info == nil {
+		return nil
 	}
-	data = append(data, '\n')
-
-	return transport.Send(ctx, data)
+	info := *c.info
+	return &info
 }
 
-// GetServerInfo returns server initialization info including available
-// commands and output styles. Returns nil if not available.
+// GetServerInfo returns the raw data of the CLI's init system message.
+// Returns nil if not available.
+//
+// Deprecated: Use ServerInfo, which is typed and also includes the
+// initialize response.
 func (c *Client) GetServerInfo() map[string]any {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -488,6 +1040,7 @@ func (c *Client) GetServerInfo() map[string]any {
 }
 
 // RewindFiles restores tracked files to their state at the specified user message.
+// It blocks until the CLI acknowledges the request or ctx is done.
 //
 // Requirements:
 //   - enableFileCheckpointing must be true
@@ -510,38 +1063,20 @@ func (c *Client) GetServerInfo() map[string]any {
 //	    return err
 //	}
 func (c *Client) RewindFiles(ctx context.Context, userMessageID string) error {
-	c.mu.RLock()
-	if !c.connected {
-		c.mu.RUnlock()
+	if !c.IsConnected() {
 		return ErrNotConnected
 	}
-	transport := c.transport
-	c.mu.RUnlock()
 
 	if userMessageID == "" {
 		return fmt.Errorf("userMessageID is required")
 	}
 
-	req := &ControlRequest{
-		Type:      MessageTypeControlRequest,
-		RequestID: generateRequestID(),
-		Request: &ControlRequestBody{
-			Subtype:       ControlSubtypeRewindFiles,
-			UserMessageID: userMessageID,
-		},
-	}
-
-	data, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-	data = append(data, '\n')
-
-	return transport.Send(ctx, data)
+	_, err := c.sendControlRequest(ctx, NewRewindFilesRequest(userMessageID))
+	return err
 }
 
 // sendInitialize sends an initialize request with hook configurations to the CLI.
-func (c *Client) sendInitialize(ctx context.Context) error {
+func (c *Client) sendInitialize(ctx context.Context) (*ControlResponsePayload, error) {
 	// Build hook definitions for the CLI
 	hookDefs := make(map[HookEvent][]InitializeHookDef)
 
@@ -568,13 +1103,109 @@ func (c *Client) sendInitialize(ctx context.Context) error {
 		},
 	}
 
+	return c.sendControlRequest(ctx, req)
+}
+
+// sendControlRequest writes a control request to the CLI and waits for the
+// control response with the matching request ID.
+// Returns a *ControlError if the CLI answers with an error response.
+func (c *Client) sendControlRequest(ctx context.Context, req *ControlRequest) (*ControlResponsePayload, error) {
+	c.mu.RLock()
+	if !c.connected {
+		c.mu.RUnlock()
+		return nil, ErrNotConnected
+	}
+	transport := c.transport
+	done := c.done
+	c.mu.RUnlock()
+
 	data, err := json.Marshal(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	data = append(data, '\n')
 
-	return c.transport.Send(ctx, data)
+	// Register before sending so a fast response is not missed
+	ch := make(chan *ControlResponsePayload, 1)
+	c.pendingMu.Lock()
+	c.pending[req.RequestID] = ch
+	c.pendingMu.Unlock()
+
+	select {
+	case c.controlWaiting <- struct{}{}:
+	default:
+	}
+
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, req.RequestID)
+		c.pendingMu.Unlock()
+	}()
+
+	if err := c.send(ctx, transport, data); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Subtype == ControlResponseError {
+			return nil, &ControlError{
+				RequestID: req.RequestID,
+				Subtype:   req.Request.Subtype,
+				Message:   resp.Error,
+			}
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-done:
+		return nil, fmt.Errorf("%w: session ended before control response", ErrCLIConnection)
+	}
+}
+
+// handleControlResponse delivers a control response from the CLI to the
+// caller waiting on the matching request ID.
+func (c *Client) handleControlResponse(raw map[string]any) {
+	response, ok := raw["response"].(map[string]any)
+	if !ok {
+		return
+	}
+
+	payload := &ControlResponsePayload{
+		Subtype:   getString(response, "subtype"),
+		RequestID: getString(response, "request_id"),
+		Response:  response["response"],
+		Error:     getString(response, "error"),
+	}
+
+	c.pendingMu.Lock()
+	ch, ok := c.pending[payload.RequestID]
+	delete(c.pending, payload.RequestID)
+	c.pendingMu.Unlock()
+
+	if ok {
+		ch <- payload
+	}
+}
+
+// runCallbacks handles queued control requests until the queue is closed.
+func (c *Client) runCallbacks() {
+	for raw := range c.callbacks {
+		c.safeHandleControlRequest(raw)
+	}
+}
+
+// safeHandleControlRequest handles a control request, reporting a panic in
+// a user callback to the CLI as a control error instead of crashing.
+func (c *Client) safeHandleControlRequest(raw map[string]any) {
+	defer func() {
+		if r := recover(); r != nil {
+			requestID, _ := raw["request_id"].(string)
+			c.sendControlError(requestID, fmt.Sprintf("callback panicked: %v", r))
+		}
+	}()
+
+	c.handleControlRequest(raw)
 }
 
 // handleControlRequest processes a control request from the CLI.
@@ -586,10 +1217,20 @@ func (c *Client) handleControlRequest(raw map[string]any) {
 	}
 
 	subtype, _ := request["subtype"].(string)
-	if subtype != "hook_callback" {
-		return
+	switch ControlRequestSubtype(subtype) {
+	case ControlSubtypeHookCallback:
+		c.handleHookCallback(requestID, request)
+	case ControlSubtypeCanUseTool:
+		c.handleCanUseTool(requestID, request)
+	case ControlSubtypeMcpMessage:
+		c.handleMCPMessage(requestID, request)
+	default:
+		// Other subtypes are not handled by the SDK
 	}
+}
 
+// handleHookCallback invokes a registered hook and sends its response.
+func (c *Client) handleHookCallback(requestID string, request map[string]any) {
 	callbackID, _ := request["callback_id"].(string)
 	input, _ := request["input"].(map[string]any)
 
@@ -602,44 +1243,180 @@ func (c *Client) handleControlRequest(raw map[string]any) {
 		return
 	}
 
-	// Extract hook event name to determine how to invoke
-	hookEventName, _ := input["hook_event_name"].(string)
+	ctx, cancel := c.callbackContext(c.cfg.hookTimeout(callbackID))
+	defer cancel()
 
-	var response *HookCallbackResponse
-	ctx := context.Background()
-	hookCtx := &HookContext{}
+	response := &HookCallbackResponse{Continue: true}
+	if output, event, err := invokeHook(ctx, callback, input, newHookContext(input)); event != "" {
+		response = c.buildHookResponse(output, err, event)
+	}
 
-	switch hookEventName {
-	case "PreToolUse":
-		if hook, ok := callback.(PreToolUseHook); ok {
-			hookInput := &PreToolUseInput{
-				ToolName:  getString(input, "tool_name"),
-				ToolInput: getMap(input, "tool_input"),
-				ToolUseID: getString(input, "tool_use_id"),
-			}
-			output, err := hook(ctx, hookInput, hookCtx)
-			response = c.buildHookResponse(output, err, PreToolUse)
-		}
-	case "PostToolUse":
-		if hook, ok := callback.(PostToolUseHook); ok {
-			hookInput := &PostToolUseInput{
-				ToolName:     getString(input, "tool_name"),
-				ToolInput:    getMap(input, "tool_input"),
-				ToolUseID:    getString(input, "tool_use_id"),
-				ToolResponse: input["tool_response"],
-				IsError:      getBool(input, "is_error"),
-			}
-			output, err := hook(ctx, hookInput, hookCtx)
-			response = c.buildHookResponse(output, err, PostToolUse)
+	// Send the response
+	c.sendControlResponse(requestID, response)
+}
+
+// newHookContext extracts the session fields the CLI sends with every hook input.
+func newHookContext(input map[string]any) *HookContext {
+	return &HookContext{
+		SessionID:      getString(input, "session_id"),
+		TranscriptPath: getString(input, "transcript_path"),
+		WorkingDir:     getString(input, "cwd"),
+		PermissionMode: getString(input, "permission_mode"),
+	}
+}
+
+// invokeHook decodes the CLI hook input for the callback's event and calls it.
+// Returns an empty event if the callback is not a known hook type.
+func invokeHook(ctx context.Context, callback any, input map[string]any, hookCtx *HookContext) (*HookOutput, HookEvent, error) {
+	switch hook := callback.(type) {
+	case PreToolUseHook:
+		output, err := hook(ctx, &PreToolUseInput{
+			ToolName:  getString(input, "tool_name"),
+			ToolInput: getMap(input, "tool_input"),
+			ToolUseID: getString(input, "tool_use_id"),
+		}, hookCtx)
+		return output, PreToolUse, err
+
+	case PostToolUseHook:
+		output, err := hook(ctx, &PostToolUseInput{
+			ToolName:     getString(input, "tool_name"),
+			ToolInput:    getMap(input, "tool_input"),
+			ToolUseID:    getString(input, "tool_use_id"),
+			ToolResponse: input["tool_response"],
+			IsError:      getBool(input, "is_error"),
+		}, hookCtx)
+		return output, PostToolUse, err
+
+	case UserPromptSubmitHook:
+		output, err := hook(ctx, &UserPromptSubmitInput{
+			Prompt:    getString(input, "prompt"),
+			SessionID: getString(input, "session_id"),
+		}, hookCtx)
+		return output, UserPromptSubmit, err
+
+	case StopHook:
+		output, err := hook(ctx, &StopInput{
+			Reason:         getString(input, "reason"),
+			SessionID:      getString(input, "session_id"),
+			StopHookActive: getBool(input, "stop_hook_active"),
+		}, hookCtx)
+		return output, Stop, err
+
+	case SubagentStopHook:
+		output, err := hook(ctx, &SubagentStopInput{
+			SubagentID:     getString(input, "agent_id"),
+			Reason:         getString(input, "reason"),
+			SessionID:      getString(input, "session_id"),
+			StopHookActive: getBool(input, "stop_hook_active"),
+		}, hookCtx)
+		return output, SubagentStop, err
+
+	case PreCompactHook:
+		output, err := hook(ctx, &PreCompactInput{
+			SessionID:          getString(input, "session_id"),
+			MessageCount:       getInt(input, "message_count"),
+			Trigger:            getString(input, "trigger"),
+			CustomInstructions: getString(input, "custom_instructions"),
+		}, hookCtx)
+		return output, PreCompact, err
+	}
+
+	return nil, "", nil
+}
+
+// handleCanUseTool invokes the permission callback and sends its decision.
+func (c *Client) handleCanUseTool(requestID string, request map[string]any) {
+	if c.cfg.canUseTool == nil {
+		c.sendControlError(requestID, "canUseTool callback is not provided")
+		return
+	}
+
+	toolName := getString(request, "tool_name")
+	input := getMap(request, "input")
+	permCtx := &ToolPermissionContext{
+		BlockedPath: getString(request, "blocked_path"),
+	}
+	for _, item := range getSlice(request, "permission_suggestions") {
+		if update, ok := item.(map[string]any); ok {
+			permCtx.Suggestions = append(permCtx.Suggestions, parsePermissionUpdate(update))
 		}
 	}
 
-	if response == nil {
-		response = &HookCallbackResponse{Continue: true}
+	ctx, cancel := c.callbackContext(0)
+	defer cancel()
+
+	result, err := c.cfg.canUseTool(ctx, toolName, input, permCtx)
+	if err != nil {
+		c.sendControlError(requestID, err.Error())
+		return
 	}
 
-	// Send the response
-	c.sendControlResponse(requestID, response)
+	c.sendControlResponse(requestID, buildPermissionResponse(result, input))
+}
+
+// buildPermissionResponse converts a PermissionResult to its wire format.
+// When allowing without modifications, the original input is echoed back,
+// or an empty object for a tool without input.
+func buildPermissionResponse(result PermissionResult, input map[string]any) *PermissionResultResponse {
+	if !result.Allow {
+		return &PermissionResultResponse{
+			Behavior:  "deny",
+			Message:   result.Message,
+			Interrupt: result.Interrupt,
+		}
+	}
+
+	updatedInput := result.UpdatedInput
+	if updatedInput == nil {
+		updatedInput = input
+	}
+	if updatedInput == nil {
+		updatedInput = map[string]any{}
+	}
+
+	return &PermissionResultResponse{
+		Behavior:           "allow",
+		UpdatedInput:       updatedInput,
+		UpdatedPermissions: result.UpdatedPermissions,
+	}
+}
+
+// parsePermissionUpdate decodes a permission update suggested by the CLI.
+func parsePermissionUpdate(m map[string]any) PermissionUpdate {
+	update := PermissionUpdate{
+		Type:        PermissionUpdateType(getString(m, "type")),
+		Behavior:    PermissionBehavior(getString(m, "behavior")),
+		Mode:        PermissionMode(getString(m, "mode")),
+		Directories: getStrings(m, "directories"),
+		Destination: PermissionUpdateDestination(getString(m, "destination")),
+	}
+	for _, item := range getSlice(m, "rules") {
+		if rule, ok := item.(map[string]any); ok {
+			update.Rules = append(update.Rules, PermissionRule{
+				ToolName:    getString(rule, "toolName"),
+				RuleContent: getString(rule, "ruleContent"),
+			})
+		}
+	}
+	return update
+}
+
+// handleMCPMessage routes a JSON-RPC message to an in-process MCP server.
+func (c *Client) handleMCPMessage(requestID string, request map[string]any) {
+	serverName := getString(request, "server_name")
+	message := getMap(request, "message")
+
+	var mcpResponse map[string]any
+	if server, ok := c.cfg.mcpServers[serverName]; ok {
+		ctx, cancel := c.callbackContext(0)
+		mcpResponse = server.handleMessage(ctx, message)
+		cancel()
+	} else {
+		mcpResponse = jsonRPCError(message["id"], jsonRPCMethodNotFound,
+			fmt.Sprintf("Server '%s' not found", serverName))
+	}
+
+	c.sendControlResponse(requestID, map[string]any{"mcp_response": mcpResponse})
 }
 
 func (c *Client) buildHookResponse(output *HookOutput, err error, event HookEvent) *HookCallbackResponse {
@@ -659,29 +1436,72 @@ func (c *Client) buildHookResponse(output *HookOutput, err error, event HookEven
 	resp.SystemMessage = output.SystemMessage
 	resp.Reason = output.Reason
 
-	if output.Decision != HookDecisionNone {
-		resp.HookSpecificOutput = &HookSpecificOutput{
-			HookEventName:     event,
-			UpdatedInput:      output.UpdatedInput,
-			AdditionalContext: output.AdditionalContext,
+	switch event {
+	case PreToolUse:
+		resp.HookSpecificOutput = buildPreToolUseOutput(output)
+
+	case PostToolUse, UserPromptSubmit:
+		if isBlockingDecision(output.Decision) {
+			resp.Decision = string(HookDecisionBlock)
+		}
+		if output.AdditionalContext != "" {
+			resp.HookSpecificOutput = &HookSpecificOutput{
+				HookEventName:     event,
+				AdditionalContext: output.AdditionalContext,
+			}
 		}
 
-		switch output.Decision {
-		case HookDecisionAllow:
-			resp.HookSpecificOutput.PermissionDecision = string(HookDecisionAllow)
-		case HookDecisionDeny:
-			resp.HookSpecificOutput.PermissionDecision = string(HookDecisionDeny)
-			resp.HookSpecificOutput.PermissionDecisionReason = output.Reason
-		case HookDecisionNone:
-			// Already handled by outer if check
+	case Stop, SubagentStop:
+		if isBlockingDecision(output.Decision) {
+			resp.Decision = string(HookDecisionBlock)
 		}
+
+	case PreCompact:
+		// PreCompact has no event-specific output
 	}
 
 	return resp
 }
 
-func (c *Client) sendControlResponse(requestID string, response *HookCallbackResponse) {
-	resp := NewControlResponseSuccess(requestID, response)
+// buildPreToolUseOutput maps a hook decision to a PreToolUse permission decision.
+func buildPreToolUseOutput(output *HookOutput) *HookSpecificOutput {
+	if output.Decision == HookDecisionNone {
+		return nil
+	}
+
+	hso := &HookSpecificOutput{
+		HookEventName:     PreToolUse,
+		UpdatedInput:      output.UpdatedInput,
+		AdditionalContext: output.AdditionalContext,
+	}
+
+	switch output.Decision {
+	case HookDecisionAllow:
+		hso.PermissionDecision = string(HookDecisionAllow)
+	case HookDecisionDeny, HookDecisionBlock:
+		hso.PermissionDecision = string(HookDecisionDeny)
+		hso.PermissionDecisionReason = output.Reason
+	case HookDecisionNone:
+		// Already handled by the early return
+	}
+
+	return hso
+}
+
+// isBlockingDecision reports whether a decision blocks a non-PreToolUse event.
+func isBlockingDecision(d HookDecision) bool {
+	return d == HookDecisionBlock || d == HookDecisionDeny
+}
+
+func (c *Client) sendControlResponse(requestID string, response any) {
+	c.writeControlResponse(NewControlResponseSuccess(requestID, response))
+}
+
+func (c *Client) sendControlError(requestID string, errMsg string) {
+	c.writeControlResponse(NewControlResponseError(requestID, errMsg))
+}
+
+func (c *Client) writeControlResponse(resp *ControlResponse) {
 	data, err := json.Marshal(resp)
 	if err != nil {
 		return
@@ -693,7 +1513,7 @@ func (c *Client) sendControlResponse(requestID string, response *HookCallbackRes
 	c.mu.RUnlock()
 
 	if transport != nil {
-		_ = transport.Send(context.Background(), data)
+		_ = c.send(context.Background(), transport, data)
 	}
 }
 
@@ -707,6 +1527,26 @@ func getMap(m map[string]any, key string) map[string]any {
 	return v
 }
 
+func getSlice(m map[string]any, key string) []any {
+	v, _ := m[key].([]any)
+	return v
+}
+
+func getStrings(m map[string]any, key string) []string {
+	var out []string
+	for _, item := range getSlice(m, key) {
+		if str, ok := item.(string); ok {
+			out = append(out, str)
+		}
+	}
+	return out
+}
+
+func getInt(m map[string]any, key string) int {
+	v, _ := m[key].(float64)
+	return int(v)
+}
+
 func getBool(m map[string]any, key string) bool {
 	v, _ := m[key].(bool)
 	return v
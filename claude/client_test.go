@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/synctest"
 	"time"
 )
 
@@ -112,6 +115,32 @@ func TestClientClose(t *testing.T) {
 			t.Errorf("Close() second call error = %v, want nil", err)
 		}
 	})
+
+	t.Run("does not lock the client while the transport closes", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		mt.onClose = func() {
+			// Like a stderr callback running during shutdown.
+			_ = client.Err()
+			_ = client.ServerInfo()
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			_ = client.Close()
+			close(closed)
+		}()
+
+		select {
+		case <-closed:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Close() did not return")
+		}
+		if client.IsConnected() {
+			t.Error("IsConnected() = true after Close()")
+		}
+	})
 }
 
 func TestClientQuery(t *testing.T) {
@@ -285,8 +314,11 @@ func TestClientParseUserMessage(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected *UserMessage, got %T", msg)
 		}
-		if um.Content != "Hello Claude" {
-			t.Errorf("Content = %q, want 'Hello Claude'", um.Content)
+		if len(um.Content) != 1 || !um.Content[0].IsText() {
+			t.Fatalf("Content = %+v, want one text block", um.Content)
+		}
+		if um.Text() != "Hello Claude" {
+			t.Errorf("Text() = %q, want 'Hello Claude'", um.Text())
 		}
 		if um.UUID != "user-uuid-123" {
 			t.Errorf("UUID = %q, want 'user-uuid-123'", um.UUID)
@@ -315,8 +347,38 @@ func TestClientParseUserMessage(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected *UserMessage, got %T", msg)
 		}
-		if um.Content != "" {
-			t.Errorf("Content = %q, want empty", um.Content)
+		if len(um.Content) != 0 {
+			t.Errorf("Content = %+v, want empty", um.Content)
+		}
+	})
+
+	t.Run("parses tool_result blocks", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"user","parent_tool_use_id":null,"message":{"role":"user","content":[` +
+			`{"type":"tool_result","tool_use_id":"toolu_1","content":"file.go","is_error":false},` +
+			`{"type":"tool_result","tool_use_id":"toolu_2","content":[{"type":"text","text":"denied"}],"is_error":true}]}}`))
+		mt.CloseMessages()
+
+		um, ok := (<-client.Messages()).(*UserMessage)
+		if !ok {
+			t.Fatal("expected *UserMessage")
+		}
+		if len(um.Content) != 2 {
+			t.Fatalf("Content length = %d, want 2", len(um.Content))
+		}
+		first, second := um.Content[0], um.Content[1]
+		if !first.IsToolResult() || first.ToolUseID != "toolu_1" || first.ToolResult != "file.go" {
+			t.Errorf("Content[0] = %+v, want tool result for toolu_1", first)
+		}
+		if !second.IsToolResult() || !second.IsError {
+			t.Errorf("Content[1] = %+v, want error tool result", second)
+		}
+		if um.Text() != "" {
+			t.Errorf("Text() = %q, want empty", um.Text())
 		}
 	})
 }
@@ -330,7 +392,7 @@ func TestClientParseSystemMessage(t *testing.T) {
 
 		sysMsg := map[string]any{
 			"type":    "system",
-			"subtype": "init",
+			"subtype": "status",
 			"data": map[string]any{
 				"version": "1.0",
 				"session": "sess-456",
@@ -345,8 +407,8 @@ func TestClientParseSystemMessage(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected *SystemMessage, got %T", msg)
 		}
-		if sm.Subtype != "init" {
-			t.Errorf("Subtype = %q, want 'init'", sm.Subtype)
+		if sm.Subtype != "status" {
+			t.Errorf("Subtype = %q, want 'status'", sm.Subtype)
 		}
 		if sm.Data["version"] != "1.0" {
 			t.Errorf("Data[version] = %v, want '1.0'", sm.Data["version"])
@@ -376,6 +438,130 @@ func TestClientParseSystemMessage(t *testing.T) {
 			t.Error("Data should be initialized to empty map, not nil")
 		}
 	})
+
+	t.Run("uses top-level fields as Data when there is no data field", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"system","subtype":"hook_response","hook_name":"SessionStart","exit_code":0}`))
+		mt.CloseMessages()
+
+		sm, ok := (<-client.Messages()).(*SystemMessage)
+		if !ok {
+			t.Fatal("expected *SystemMessage")
+		}
+		if sm.Data["hook_name"] != "SessionStart" {
+			t.Errorf("Data[hook_name] = %v, want 'SessionStart'", sm.Data["hook_name"])
+		}
+		if _, ok := sm.Data["type"]; ok {
+			t.Error("Data should not contain type")
+		}
+	})
+
+	t.Run("parses init into SystemInitMessage", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"system","subtype":"init","session_id":"sess-1","uuid":"u-1",` +
+			`"apiKeySource":"user","cwd":"/work","tools":["Bash"],"mcp_servers":[{"name":"calc","status":"failed"}],` +
+			`"model":"claude-sonnet-4-5","permissionMode":"plan","slash_commands":["compact"],` +
+			`"claude_code_version":"2.0.1","agents":["reviewer"]}`))
+		mt.CloseMessages()
+
+		msg := <-client.Messages()
+		initMsg, ok := msg.(*SystemInitMessage)
+		if !ok {
+			t.Fatalf("expected *SystemInitMessage, got %T", msg)
+		}
+		if initMsg.SessionID != "sess-1" || initMsg.UUID != "u-1" || initMsg.Cwd != "/work" || initMsg.Model != "claude-sonnet-4-5" {
+			t.Errorf("SystemInitMessage = %+v, want top-level fields decoded", initMsg)
+		}
+		if initMsg.PermissionMode != PermissionPlan || initMsg.APIKeySource != "user" || initMsg.CLIVersion != "2.0.1" {
+			t.Errorf("SystemInitMessage = %+v, want settings decoded", initMsg)
+		}
+		if len(initMsg.Tools) != 1 || len(initMsg.SlashCommands) != 1 {
+			t.Errorf("Tools = %v, SlashCommands = %v", initMsg.Tools, initMsg.SlashCommands)
+		}
+		if len(initMsg.MCPServers) != 1 || initMsg.MCPServers[0].Status != "failed" {
+			t.Errorf("MCPServers = %+v, want calc failed", initMsg.MCPServers)
+		}
+		if string(initMsg.Extra["agents"]) != `["reviewer"]` {
+			t.Errorf("Extra[agents] = %s, want raw JSON", initMsg.Extra["agents"])
+		}
+		if _, ok := initMsg.Extra["session_id"]; ok {
+			t.Error("Extra should not contain decoded fields")
+		}
+	})
+
+	t.Run("parses init with an unexpected field type", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"system","subtype":"init","session_id":"sess-1",` +
+			`"mcp_servers":{"a":"connected"},"model":"claude-sonnet-4-5","tools":["Bash"]}`))
+		mt.CloseMessages()
+
+		msg := <-client.Messages()
+		initMsg, ok := msg.(*SystemInitMessage)
+		if !ok {
+			t.Fatalf("expected *SystemInitMessage, got %T", msg)
+		}
+		if initMsg.SessionID != "sess-1" || initMsg.Model != "claude-sonnet-4-5" || len(initMsg.Tools) != 1 {
+			t.Errorf("SystemInitMessage = %+v, want the other fields decoded", initMsg)
+		}
+		if info := client.ServerInfo(); info.SessionID != "sess-1" {
+			t.Errorf("ServerInfo().SessionID = %q, want sess-1", info.SessionID)
+		}
+	})
+
+	t.Run("parses compact_boundary into SystemCompactBoundaryMessage", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"system","subtype":"compact_boundary","session_id":"sess-1",` +
+			`"uuid":"u-2","compact_metadata":{"trigger":"auto","pre_tokens":150000}}`))
+		mt.CloseMessages()
+
+		msg := <-client.Messages()
+		boundary, ok := msg.(*SystemCompactBoundaryMessage)
+		if !ok {
+			t.Fatalf("expected *SystemCompactBoundaryMessage, got %T", msg)
+		}
+		if boundary.Metadata.Trigger != "auto" || boundary.Metadata.PreTokens != 150000 {
+			t.Errorf("Metadata = %+v, want auto/150000", boundary.Metadata)
+		}
+		if boundary.Extra != nil {
+			t.Errorf("Extra = %v, want nil", boundary.Extra)
+		}
+	})
+
+	t.Run("parses compact_boundary with an unexpected field type", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"system","subtype":"compact_boundary","session_id":"sess-1",` +
+			`"compact_metadata":{"trigger":"manual","pre_tokens":"many"}}`))
+		mt.CloseMessages()
+
+		msg := <-client.Messages()
+		boundary, ok := msg.(*SystemCompactBoundaryMessage)
+		if !ok {
+			t.Fatalf("expected *SystemCompactBoundaryMessage, got %T", msg)
+		}
+		if boundary.SessionID != "sess-1" || boundary.Metadata.Trigger != "manual" {
+			t.Errorf("SystemCompactBoundaryMessage = %+v, want the other fields decoded", boundary)
+		}
+	})
 }
 
 func TestClientParseAssistantMessage(t *testing.T) {
@@ -410,6 +596,33 @@ func TestClientParseAssistantMessage(t *testing.T) {
 			t.Errorf("Error = %q, want 'something went wrong'", am.Error)
 		}
 	})
+
+	t.Run("parses id, stop_reason and usage", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"assistant","message":{"id":"msg_01","model":"claude-sonnet-4-5",` +
+			`"stop_reason":"tool_use","content":[],"usage":{"input_tokens":12,"output_tokens":34,` +
+			`"cache_read_input_tokens":56,"service_tier":"standard"}}}`))
+		mt.CloseMessages()
+
+		am, ok := (<-client.Messages()).(*AssistantMessage)
+		if !ok {
+			t.Fatal("expected *AssistantMessage")
+		}
+		if am.ID != "msg_01" {
+			t.Errorf("ID = %q, want 'msg_01'", am.ID)
+		}
+		if am.StopReason != "tool_use" {
+			t.Errorf("StopReason = %q, want 'tool_use'", am.StopReason)
+		}
+		want := Usage{InputTokens: 12, OutputTokens: 34, CacheReadInputTokens: 56, ServiceTier: "standard"}
+		if am.Usage != want {
+			t.Errorf("Usage = %+v, want %+v", am.Usage, want)
+		}
+	})
 }
 
 func TestClientParseContentBlocks(t *testing.T) {
@@ -605,9 +818,62 @@ func TestClientParseContentBlocks(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected *AssistantMessage, got %T", msg)
 		}
-		// Unknown types are skipped, only valid text block should be parsed
-		if len(am.Content) != 1 {
-			t.Fatalf("Content length = %d, want 1", len(am.Content))
+		// Unknown types are kept as BlockUnknown with their raw JSON
+		if len(am.Content) != 2 {
+			t.Fatalf("Content length = %d, want 2", len(am.Content))
+		}
+		unknown := am.Content[0]
+		if !unknown.IsUnknown() {
+			t.Errorf("Kind = %v, want BlockUnknown", unknown.Kind)
+		}
+		var raw map[string]any
+		if err := json.Unmarshal(unknown.Raw, &raw); err != nil || raw["type"] != "unknown_type" || raw["data"] != "some data" {
+			t.Errorf("Raw = %s, want original block JSON", unknown.Raw)
+		}
+		if am.Content[1].Text != "valid text" {
+			t.Errorf("Content[1].Text = %q, want 'valid text'", am.Content[1].Text)
+		}
+	})
+
+	t.Run("parses server tool, redacted thinking and media blocks", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"assistant","message":{"model":"claude-sonnet-4-5","content":[` +
+			`{"type":"redacted_thinking","data":"ENCRYPTED"},` +
+			`{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{"query":"go iterators"}},` +
+			`{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[{"type":"web_search_result","url":"https://go.dev","title":"Go"}]},` +
+			`{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0K"}},` +
+			`{"type":"document","title":"Spec","source":{"type":"url","url":"https://example.com/spec.pdf"}}]}}`))
+		mt.CloseMessages()
+
+		am, ok := (<-client.Messages()).(*AssistantMessage)
+		if !ok {
+			t.Fatal("expected *AssistantMessage")
+		}
+		if len(am.Content) != 5 {
+			t.Fatalf("Content length = %d, want 5", len(am.Content))
+		}
+
+		redacted, serverTool, search, image, doc := am.Content[0], am.Content[1], am.Content[2], am.Content[3], am.Content[4]
+		if !redacted.IsRedactedThinking() || redacted.Data != "ENCRYPTED" {
+			t.Errorf("Content[0] = %+v, want redacted thinking", redacted)
+		}
+		if !serverTool.IsServerToolUse() || serverTool.ToolName != "web_search" || serverTool.ToolInput["query"] != "go iterators" {
+			t.Errorf("Content[1] = %+v, want web_search server tool use", serverTool)
+		}
+		results, _ := search.ToolResult.([]any)
+		if !search.IsWebSearchToolResult() || search.ToolUseID != "srvtoolu_1" || len(results) != 1 {
+			t.Errorf("Content[2] = %+v, want one web search result", search)
+		}
+		wantImage := ContentSource{Type: "base64", MediaType: "image/png", Data: "iVBORw0K"}
+		if !image.IsImage() || image.Source == nil || *image.Source != wantImage {
+			t.Errorf("Content[3] = %+v, want base64 png image", image)
+		}
+		if !doc.IsDocument() || doc.Title != "Spec" || doc.Source == nil || doc.Source.URL != "https://example.com/spec.pdf" {
+			t.Errorf("Content[4] = %+v, want URL document", doc)
 		}
 	})
 }
@@ -634,25 +900,125 @@ func TestClientParseMessage(t *testing.T) {
 		}
 	})
 
-	t.Run("returns nil for unknown message type", func(t *testing.T) {
+	t.Run("returns UnknownMessage for unknown message type", func(t *testing.T) {
 		mt := newMockTransport()
 		client := NewClient(WithTransport(mt))
 		_ = client.Connect(context.Background())
 		defer client.Close()
 
-		mt.QueueMessage([]byte(`{"type":"unknown_type"}`))
+		mt.QueueMessage([]byte(`{"type":"unknown_type","value":1}`))
 		mt.QueueMessage([]byte(`{"type":"result","subtype":"success"}`))
 		mt.CloseMessages()
 
-		// First message (unknown type) should be skipped
 		msg := <-client.Messages()
-		_, ok := msg.(*ResultMessage)
+		unknown, ok := msg.(*UnknownMessage)
 		if !ok {
-			t.Fatalf("expected *ResultMessage, got %T", msg)
+			t.Fatalf("expected *UnknownMessage, got %T", msg)
+		}
+		if unknown.Type != "unknown_type" || string(unknown.Raw) != `{"type":"unknown_type","value":1}` {
+			t.Errorf("UnknownMessage = {%q, %s}, want unknown_type with raw JSON", unknown.Type, unknown.Raw)
+		}
+		if _, ok := (<-client.Messages()).(*ResultMessage); !ok {
+			t.Error("expected *ResultMessage after the unknown message")
+		}
+	})
+
+	t.Run("skips malformed lines", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"result",`))
+		mt.QueueMessage([]byte(`{"type":"result","subtype":"success"}`))
+		mt.CloseMessages()
+
+		if _, ok := (<-client.Messages()).(*ResultMessage); !ok {
+			t.Error("expected *ResultMessage after the malformed line")
+		}
+	})
+
+	t.Run("reports malformed lines to the message error callback", func(t *testing.T) {
+		mt := newMockTransport()
+		var reported []error
+		client := NewClient(WithTransport(mt), WithMessageErrorCallback(func(err error) {
+			reported = append(reported, err)
+		}))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"result",`))
+		mt.QueueMessage([]byte(`{"type":"result","subtype":"success"}`))
+		mt.CloseMessages()
+		<-client.Messages()
+
+		var decodeErr *JSONDecodeError
+		if len(reported) != 1 || !errors.As(reported[0], &decodeErr) {
+			t.Errorf("reported = %v, want one *JSONDecodeError", reported)
+		}
+	})
+
+	t.Run("keeps raw JSON on every message type", func(t *testing.T) {
+		lines := []string{
+			`{"type":"user","message":{"content":"hi"},"new_field":1}`,
+			`{"type":"assistant","message":{"content":[],"model":"m"},"new_field":1}`,
+			`{"type":"system","subtype":"init","session_id":"s","new_field":1}`,
+			`{"type":"system","subtype":"compact_boundary","new_field":1}`,
+			`{"type":"system","subtype":"status","new_field":1}`,
+			`{"type":"stream_event","event":{"type":"message_stop"},"new_field":1}`,
+			`{"type":"result","subtype":"success","new_field":1}`,
+		}
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+		queueJSON(t, mt, lines...)
+
+		for _, line := range lines {
+			msg := <-client.Messages()
+			raw := reflect.ValueOf(msg).Elem().FieldByName("Raw").Bytes()
+			if string(raw) != line {
+				t.Errorf("%T Raw = %s, want %s", msg, raw, line)
+			}
 		}
 	})
 }
 
+func TestClientStrictDecoding(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"unknown message type", `{"type":"new_kind"}`},
+		{"malformed line", `{"type":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" ends the session", func(t *testing.T) {
+			mt := newMockTransport()
+			client := NewClient(WithTransport(mt), WithStrictDecoding(true))
+			_ = client.Connect(context.Background())
+			defer client.Close()
+
+			mt.QueueMessage([]byte(tt.line))
+			mt.QueueMessage([]byte(testResultJSON))
+
+			for msg := range client.Messages() {
+				t.Errorf("unexpected message %T", msg)
+			}
+			<-client.Done()
+
+			var decodeErr *JSONDecodeError
+			if !errors.As(client.Err(), &decodeErr) {
+				t.Fatalf("Err() = %v, want *JSONDecodeError", client.Err())
+			}
+			if decodeErr.Line != tt.line {
+				t.Errorf("Line = %q, want %q", decodeErr.Line, tt.line)
+			}
+		})
+	}
+}
+
 func TestClientCloseWithTransportError(t *testing.T) {
 	t.Run("close returns transport error", func(t *testing.T) {
 		mt := newMockTransport()
@@ -708,12 +1074,12 @@ func TestClientSendInitializeWithTimeout(t *testing.T) {
 		defer client.Close()
 
 		// Check that the initialize request was sent with timeout
-		if len(mt.sentMessages) == 0 {
-			t.Fatal("no messages sent on connect")
+		if len(mt.initRequests) == 0 {
+			t.Fatal("no initialize request sent on connect")
 		}
 
 		var initReq map[string]any
-		if err := json.Unmarshal(mt.sentMessages[0], &initReq); err != nil {
+		if err := json.Unmarshal(mt.initRequests[0], &initReq); err != nil {
 			t.Fatalf("failed to unmarshal init request: %v", err)
 		}
 
@@ -753,8 +1119,22 @@ func TestClientParseResultMessage(t *testing.T) {
 			"session_id":      "sess-abc123",
 			"total_cost_usd":  0.05,
 			"usage": map[string]any{
-				"input_tokens":  100.0,
-				"output_tokens": 200.0,
+				"input_tokens":                100.0,
+				"output_tokens":               200.0,
+				"cache_creation_input_tokens": 30.0,
+				"cache_read_input_tokens":     400.0,
+				"server_tool_use":             map[string]any{"web_search_requests": 2.0},
+			},
+			"modelUsage": map[string]any{
+				"claude-sonnet-4-5": map[string]any{
+					"inputTokens":  100.0,
+					"outputTokens": 200.0,
+					"costUSD":      0.04,
+				},
+				"claude-haiku-4-5": map[string]any{
+					"inputTokens": 10.0,
+					"costUSD":     0.01,
+				},
 			},
 			"result":            "Task completed successfully",
 			"structured_output": map[string]any{"key": "value"},
@@ -786,8 +1166,25 @@ func TestClientParseResultMessage(t *testing.T) {
 		if rm.TotalCostUSD != 0.05 {
 			t.Errorf("TotalCostUSD = %f, want 0.05", rm.TotalCostUSD)
 		}
-		if rm.Usage == nil {
-			t.Error("Usage should not be nil")
+		wantUsage := Usage{
+			InputTokens:              100,
+			OutputTokens:             200,
+			CacheCreationInputTokens: 30,
+			CacheReadInputTokens:     400,
+			ServerToolUse:            ServerToolUsage{WebSearchRequests: 2},
+		}
+		if rm.Usage != wantUsage {
+			t.Errorf("Usage = %+v, want %+v", rm.Usage, wantUsage)
+		}
+		if len(rm.ModelUsage) != 2 {
+			t.Fatalf("ModelUsage length = %d, want 2", len(rm.ModelUsage))
+		}
+		sonnet := rm.ModelUsage["claude-sonnet-4-5"]
+		if sonnet.OutputTokens != 200 || sonnet.CostUSD != 0.04 {
+			t.Errorf("ModelUsage[sonnet] = %+v, want 200 output tokens at $0.04", sonnet)
+		}
+		if rm.ModelUsage["claude-haiku-4-5"].CostUSD != 0.01 {
+			t.Errorf("ModelUsage[haiku].CostUSD = %v, want 0.01", rm.ModelUsage["claude-haiku-4-5"].CostUSD)
 		}
 		if rm.Result != "Task completed successfully" {
 			t.Errorf("Result = %q, want 'Task completed successfully'", rm.Result)
@@ -796,6 +1193,60 @@ func TestClientParseResultMessage(t *testing.T) {
 			t.Error("StructuredOutput should not be nil")
 		}
 	})
+
+	t.Run("parses permission denials and errors", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+		queueJSON(t, mt, `{"type":"result","subtype":"error_during_execution","is_error":true,`+
+			`"permission_denials":[{"tool_name":"Bash","tool_use_id":"tu_1","tool_input":{"command":"rm -rf /"}}],`+
+			`"errors":["interrupted"]}`)
+
+		rm, ok := (<-client.Messages()).(*ResultMessage)
+		if !ok {
+			t.Fatal("expected *ResultMessage")
+		}
+
+		want := []PermissionDenial{{
+			ToolName:  "Bash",
+			ToolUseID: "tu_1",
+			ToolInput: map[string]any{"command": "rm -rf /"},
+		}}
+		if !reflect.DeepEqual(rm.PermissionDenials, want) {
+			t.Errorf("PermissionDenials = %+v, want %+v", rm.PermissionDenials, want)
+		}
+		if len(rm.Errors) != 1 || rm.Errors[0] != "interrupted" {
+			t.Errorf("Errors = %v, want [interrupted]", rm.Errors)
+		}
+		if !errors.Is(rm.Err(), ErrExecution) {
+			t.Errorf("Err() = %v, want %v", rm.Err(), ErrExecution)
+		}
+	})
+
+	t.Run("failed result takes API error kind from assistant message", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+		queueJSON(t, mt,
+			`{"type":"assistant","error":"authentication_failed","message":{"content":[{"type":"text","text":"Invalid API key"}],"model":"m"}}`,
+			`{"type":"result","subtype":"success","is_error":true,"result":"Invalid API key"}`,
+			`{"type":"result","subtype":"success","is_error":true,"result":"Later failure"}`,
+		)
+
+		<-client.Messages()
+		first, _ := (<-client.Messages()).(*ResultMessage)
+		second, _ := (<-client.Messages()).(*ResultMessage)
+
+		var apiErr *APIError
+		if !errors.As(first.Err(), &apiErr) || apiErr.Kind != APIErrorAuthenticationFailed {
+			t.Errorf("first Err() = %v, want authentication_failed APIError", first.Err())
+		}
+		if errors.As(second.Err(), &apiErr) || !errors.Is(second.Err(), ErrExecution) {
+			t.Errorf("second Err() = %v, want %v", second.Err(), ErrExecution)
+		}
+	})
 }
 
 func TestClientHandleControlRequestMalformed(t *testing.T) {
@@ -857,63 +1308,280 @@ func TestClientHandleControlRequestMalformed(t *testing.T) {
 	})
 }
 
-func TestClient_Interrupt(t *testing.T) {
-	t.Run("sends interrupt control request", func(t *testing.T) {
+func TestClientCanUseTool(t *testing.T) {
+	t.Run("invokes callback and sends allow response", func(t *testing.T) {
+		var gotTool string
+		var gotCtx *ToolPermissionContext
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
+			gotTool = toolName
+			gotCtx = permCtx
+			return PermissionResult{Allow: true, UpdatedInput: map[string]any{"command": "ls -la"}}, nil
+		}
+
 		mt := newMockTransport()
-		client := NewClient(WithTransport(mt))
+		client := NewClient(WithTransport(mt), WithCanUseTool(fn))
 		_ = client.Connect(context.Background())
+		defer client.Close()
 
-		err := client.Interrupt(context.Background())
+		controlReq := `{"type":"control_request","request_id":"req-perm","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{"command":"ls"},"permission_suggestions":[{"type":"addRules"}],"blocked_path":"/etc"}}`
+		mt.QueueMessage([]byte(controlReq))
+		mt.CloseMessages()
 
-		if err != nil {
-			t.Errorf("Interrupt() error = %v, want nil", err)
+		for range client.Messages() {
+		}
+
+		if gotTool != "Bash" {
+			t.Errorf("toolName = %q, want 'Bash'", gotTool)
+		}
+		if gotCtx == nil || gotCtx.BlockedPath != "/etc" || len(gotCtx.Suggestions) != 1 {
+			t.Errorf("permCtx = %+v, want blocked path and one suggestion", gotCtx)
 		}
 		if len(mt.sentMessages) != 1 {
 			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
 		}
 
-		var msg map[string]any
-		if err := json.Unmarshal(mt.sentMessages[0], &msg); err != nil {
-			t.Fatalf("failed to unmarshal sent message: %v", err)
+		var resp ControlResponse
+		if err := json.Unmarshal(mt.sentMessages[0], &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
 		}
-		if msg["type"] != MessageTypeControlRequest {
-			t.Errorf("message type = %v, want %v", msg["type"], MessageTypeControlRequest)
+		if resp.Response.Subtype != "success" || resp.Response.RequestID != "req-perm" {
+			t.Errorf("response = %+v, want success for req-perm", resp.Response)
 		}
-		request, _ := msg["request"].(map[string]any)
-		if request["subtype"] != string(ControlSubtypeInterrupt) {
-			t.Errorf("request subtype = %v, want %v", request["subtype"], ControlSubtypeInterrupt)
+		result, _ := resp.Response.Response.(map[string]any)
+		if result["behavior"] != "allow" {
+			t.Errorf("behavior = %v, want 'allow'", result["behavior"])
+		}
+		updated, _ := result["updatedInput"].(map[string]any)
+		if updated["command"] != "ls -la" {
+			t.Errorf("updatedInput = %v, want command 'ls -la'", updated)
 		}
 	})
-}
 
-func TestClient_Interrupt_NotConnected(t *testing.T) {
-	t.Run("fails when not connected", func(t *testing.T) {
-		client := NewClient()
-
-		err := client.Interrupt(context.Background())
-
-		if !errors.Is(err, ErrNotConnected) {
-			t.Errorf("Interrupt() error = %v, want %v", err, ErrNotConnected)
+	t.Run("sends empty updated input for a tool without arguments", func(t *testing.T) {
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
+			return PermissionResult{Allow: true}, nil
 		}
-	})
-}
 
-func TestClient_SetPermissionMode(t *testing.T) {
-	t.Run("sends set permission mode control request", func(t *testing.T) {
 		mt := newMockTransport()
-		client := NewClient(WithTransport(mt))
+		client := NewClient(WithTransport(mt), WithCanUseTool(fn))
 		_ = client.Connect(context.Background())
+		defer client.Close()
 
-		err := client.SetPermissionMode(context.Background(), PermissionAcceptEdits)
+		mt.QueueMessage([]byte(`{"type":"control_request","request_id":"req-noargs","request":{"subtype":"can_use_tool","tool_name":"ExitPlanMode"}}`))
+		mt.CloseMessages()
 
-		if err != nil {
-			t.Errorf("SetPermissionMode() error = %v, want nil", err)
+		for range client.Messages() {
 		}
-		if len(mt.sentMessages) != 1 {
-			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+
+		if len(mt.sentMessages) != 1 || !strings.Contains(string(mt.sentMessages[0]), `"updatedInput":{}`) {
+			t.Errorf("sentMessages = %q, want an allow response with empty updatedInput", mt.sentMessages)
 		}
+	})
 
-		var msg map[string]any
+	t.Run("decodes suggestions and sends updated permissions", func(t *testing.T) {
+		var suggestions []PermissionUpdate
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
+			suggestions = permCtx.Suggestions
+			return PermissionResult{Allow: true, UpdatedPermissions: permCtx.Suggestions}, nil
+		}
+
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt), WithCanUseTool(fn))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		suggestion := `{"type":"addRules","rules":[{"toolName":"Bash","ruleContent":"npm test:*"}],"behavior":"allow","destination":"localSettings"}`
+		mt.QueueMessage([]byte(`{"type":"control_request","request_id":"req-perm","request":{"subtype":"can_use_tool","tool_name":"Bash",` +
+			`"input":{"command":"npm test"},"permission_suggestions":[` + suggestion + `,{"type":"setMode","mode":"acceptEdits","destination":"session"}]}}`))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		want := []PermissionUpdate{
+			{
+				Type:        PermissionUpdateAddRules,
+				Rules:       []PermissionRule{{ToolName: "Bash", RuleContent: "npm test:*"}},
+				Behavior:    PermissionBehaviorAllow,
+				Destination: PermissionDestinationLocalSettings,
+			},
+			{Type: PermissionUpdateSetMode, Mode: PermissionAcceptEdits, Destination: PermissionDestinationSession},
+		}
+		if !reflect.DeepEqual(suggestions, want) {
+			t.Errorf("Suggestions = %+v, want %+v", suggestions, want)
+		}
+
+		var resp struct {
+			Response struct {
+				Response struct {
+					UpdatedPermissions []json.RawMessage `json:"updatedPermissions"`
+				} `json:"response"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(mt.sentMessages[0], &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		updates := resp.Response.Response.UpdatedPermissions
+		if len(updates) != 2 || string(updates[0]) != suggestion {
+			t.Errorf("updatedPermissions = %s, want the suggestions", updates)
+		}
+	})
+
+	t.Run("sends deny response with interrupt", func(t *testing.T) {
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
+			return PermissionResult{Allow: false, Message: "no", Interrupt: true}, nil
+		}
+
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt), WithCanUseTool(fn))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"control_request","request_id":"req-deny","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{}}}`))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+		msg := string(mt.sentMessages[0])
+		if !strings.Contains(msg, `"behavior":"deny"`) || !strings.Contains(msg, `"interrupt":true`) {
+			t.Errorf("response should deny with interrupt, got: %s", msg)
+		}
+		if strings.Contains(msg, "updatedInput") {
+			t.Errorf("deny response should omit updatedInput, got: %s", msg)
+		}
+	})
+
+	t.Run("echoes original input when allowing without changes", func(t *testing.T) {
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
+			return PermissionResult{Allow: true}, nil
+		}
+
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt), WithCanUseTool(fn))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"control_request","request_id":"req-echo","request":{"subtype":"can_use_tool","tool_name":"Read","input":{"file_path":"/tmp/x"}}}`))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+		if !strings.Contains(string(mt.sentMessages[0]), `"updatedInput":{"file_path":"/tmp/x"}`) {
+			t.Errorf("response should echo original input, got: %s", mt.sentMessages[0])
+		}
+	})
+
+	t.Run("sends error response when callback fails", func(t *testing.T) {
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
+			return PermissionResult{}, errors.New("callback failed")
+		}
+
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt), WithCanUseTool(fn))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"control_request","request_id":"req-fail","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{}}}`))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+		msg := string(mt.sentMessages[0])
+		if !strings.Contains(msg, `"subtype":"error"`) || !strings.Contains(msg, "callback failed") {
+			t.Errorf("response should be an error, got: %s", msg)
+		}
+	})
+
+	t.Run("sends error response when no callback is configured", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"control_request","request_id":"req-none","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{}}}`))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+		if !strings.Contains(string(mt.sentMessages[0]), `"subtype":"error"`) {
+			t.Errorf("response should be an error, got: %s", mt.sentMessages[0])
+		}
+	})
+}
+
+func TestClient_Interrupt(t *testing.T) {
+	t.Run("sends interrupt control request", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+
+		err := client.Interrupt(context.Background())
+
+		if err != nil {
+			t.Errorf("Interrupt() error = %v, want nil", err)
+		}
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+
+		var msg map[string]any
+		if err := json.Unmarshal(mt.sentMessages[0], &msg); err != nil {
+			t.Fatalf("failed to unmarshal sent message: %v", err)
+		}
+		if msg["type"] != MessageTypeControlRequest {
+			t.Errorf("message type = %v, want %v", msg["type"], MessageTypeControlRequest)
+		}
+		request, _ := msg["request"].(map[string]any)
+		if request["subtype"] != string(ControlSubtypeInterrupt) {
+			t.Errorf("request subtype = %v, want %v", request["subtype"], ControlSubtypeInterrupt)
+		}
+	})
+}
+
+func TestClient_Interrupt_NotConnected(t *testing.T) {
+	t.Run("fails when not connected", func(t *testing.T) {
+		client := NewClient()
+
+		err := client.Interrupt(context.Background())
+
+		if !errors.Is(err, ErrNotConnected) {
+			t.Errorf("Interrupt() error = %v, want %v", err, ErrNotConnected)
+		}
+	})
+}
+
+func TestClient_SetPermissionMode(t *testing.T) {
+	t.Run("sends set permission mode control request", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+
+		err := client.SetPermissionMode(context.Background(), PermissionAcceptEdits)
+
+		if err != nil {
+			t.Errorf("SetPermissionMode() error = %v, want nil", err)
+		}
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+
+		var msg map[string]any
 		if err := json.Unmarshal(mt.sentMessages[0], &msg); err != nil {
 			t.Fatalf("failed to unmarshal sent message: %v", err)
 		}
@@ -1012,6 +1680,410 @@ func TestClient_SetModel_NotConnected(t *testing.T) {
 	})
 }
 
+func TestClientControlRequestCorrelation(t *testing.T) {
+	t.Run("returns ControlError when CLI answers with error", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.controlErr = "unknown model"
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		err := client.SetModel(context.Background(), "not-a-model")
+
+		var ctrlErr *ControlError
+		if !errors.As(err, &ctrlErr) {
+			t.Fatalf("SetModel() error = %v, want *ControlError", err)
+		}
+		if ctrlErr.Subtype != ControlSubtypeSetModel {
+			t.Errorf("Subtype = %q, want %q", ctrlErr.Subtype, ControlSubtypeSetModel)
+		}
+		if ctrlErr.Message != "unknown model" {
+			t.Errorf("Message = %q, want 'unknown model'", ctrlErr.Message)
+		}
+	})
+
+	t.Run("waits until context expires without response", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+		mt.IgnoreControl()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := client.Interrupt(ctx)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Interrupt() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("returns when session ends before response", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+		mt.IgnoreControl()
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			mt.CloseMessages()
+		}()
+
+		err := client.SetPermissionMode(context.Background(), PermissionPlan)
+
+		if !errors.Is(err, ErrCLIConnection) {
+			t.Errorf("SetPermissionMode() error = %v, want %v", err, ErrCLIConnection)
+		}
+	})
+
+	t.Run("delivers response payload to matching request", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		resp, err := client.sendControlRequest(context.Background(), NewInterruptRequest())
+
+		if err != nil {
+			t.Fatalf("sendControlRequest() error = %v", err)
+		}
+		var sent ControlRequest
+		if err := json.Unmarshal(mt.sentMessages[0], &sent); err != nil {
+			t.Fatalf("failed to unmarshal sent request: %v", err)
+		}
+		if resp.RequestID != sent.RequestID {
+			t.Errorf("RequestID = %q, want %q", resp.RequestID, sent.RequestID)
+		}
+		if resp.Subtype != ControlResponseSuccess {
+			t.Errorf("Subtype = %q, want %q", resp.Subtype, ControlResponseSuccess)
+		}
+	})
+
+	t.Run("ignores responses for unknown request IDs", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"control_response","response":{"subtype":"success","request_id":"req-stray"}}`))
+		mt.QueueMessage([]byte(`{"type":"result","subtype":"success"}`))
+		mt.CloseMessages()
+
+		msg := <-client.Messages()
+		if _, ok := msg.(*ResultMessage); !ok {
+			t.Fatalf("expected *ResultMessage, got %T", msg)
+		}
+	})
+
+	t.Run("does not wait for undelivered messages", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+			pending := 3 * client.cfg.messageBuffer()
+			go func() {
+				for range pending {
+					mt.QueueMessage([]byte(`{"type":"stream_event","event":{"type":"message_start"}}`))
+				}
+				mt.QueueMessage([]byte(testResultJSON))
+			}()
+			synctest.Wait()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			received := 0
+			for msg := range client.Messages() {
+				received++
+				if received == 1 {
+					if err := client.Interrupt(ctx); err != nil {
+						t.Fatalf("Interrupt() error = %v", err)
+					}
+					if err := client.SetModel(ctx, "m"); err != nil {
+						t.Fatalf("SetModel() error = %v", err)
+					}
+				}
+				if _, ok := msg.(*ResultMessage); ok {
+					break
+				}
+			}
+
+			if received != pending+1 {
+				t.Errorf("received %d messages, want %d", received, pending+1)
+			}
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("stops reading while the buffer is full", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+			stop := make(chan struct{})
+			go func() {
+				for {
+					select {
+					case mt.messagesCh <- []byte(`{"type":"stream_event","event":{"type":"message_start"}}`):
+					case <-stop:
+						return
+					}
+				}
+			}()
+			synctest.Wait()
+
+			if unread := len(mt.messagesCh); unread != cap(mt.messagesCh) {
+				t.Errorf("transport has %d unread lines, want %d", unread, cap(mt.messagesCh))
+			}
+			close(stop)
+			closeSession(client, mt)
+		})
+	})
+}
+
+func TestClientDoneAndErr(t *testing.T) {
+	t.Run("reports transport error when session ends", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueError(&ProcessError{ExitCode: 1, Stderr: "boom"})
+		mt.CloseMessages()
+
+		select {
+		case <-client.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Done() was not closed")
+		}
+		for range client.Messages() {
+		}
+
+		var procErr *ProcessError
+		if !errors.As(client.Err(), &procErr) {
+			t.Fatalf("Err() = %v, want *ProcessError", client.Err())
+		}
+		if procErr.Stderr != "boom" {
+			t.Errorf("Stderr = %q, want 'boom'", procErr.Stderr)
+		}
+	})
+
+	t.Run("Err is nil while running and after Close", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+
+		if err := client.Err(); err != nil {
+			t.Errorf("Err() = %v, want nil while running", err)
+		}
+		select {
+		case <-client.Done():
+			t.Fatal("Done() closed while running")
+		default:
+		}
+
+		_ = client.Close()
+
+		select {
+		case <-client.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Done() was not closed after Close")
+		}
+		if err := client.Err(); err != nil {
+			t.Errorf("Err() = %v, want nil after Close", err)
+		}
+	})
+
+	t.Run("Done is nil before Connect", func(t *testing.T) {
+		client := NewClient()
+
+		if client.Done() != nil {
+			t.Error("Done() should be nil before Connect")
+		}
+	})
+}
+
+func TestClientSessionContext(t *testing.T) {
+	t.Run("cancelling the Connect context does not end the session", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		ctx, cancel := context.WithCancel(context.Background())
+		_ = client.Connect(ctx)
+		defer client.Close()
+
+		cancel()
+
+		select {
+		case <-client.Done():
+			t.Fatal("Done() closed after Connect context was cancelled")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if !client.IsConnected() {
+			t.Error("IsConnected() = false, want true")
+		}
+	})
+
+	t.Run("WithSessionContext closes the client when done", func(t *testing.T) {
+		mt := newMockTransport()
+		ctx, cancel := context.WithCancel(context.Background())
+		client := NewClient(WithTransport(mt), WithSessionContext(ctx))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		cancel()
+
+		select {
+		case <-client.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Done() was not closed after session context was cancelled")
+		}
+		if client.IsConnected() {
+			t.Error("IsConnected() = true, want false")
+		}
+		if !errors.Is(client.Err(), context.Canceled) {
+			t.Errorf("Err() = %v, want context.Canceled", client.Err())
+		}
+	})
+
+	t.Run("Close before the session context is done leaves Err nil", func(t *testing.T) {
+		mt := newMockTransport()
+		ctx, cancel := context.WithCancel(context.Background())
+		client := NewClient(WithTransport(mt), WithSessionContext(ctx))
+		_ = client.Connect(context.Background())
+
+		_ = client.Close()
+		cancel()
+
+		if err := client.Err(); err != nil {
+			t.Errorf("Err() = %v, want nil", err)
+		}
+	})
+}
+
+func TestClientServerInfo(t *testing.T) {
+	t.Run("returns nil before Connect", func(t *testing.T) {
+		client := NewClient()
+
+		if info := client.ServerInfo(); info != nil {
+			t.Errorf("ServerInfo() = %+v, want nil", info)
+		}
+	})
+
+	t.Run("Connect records the initialize response", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.initResponse = map[string]any{
+			"commands": []any{
+				map[string]any{"name": "review", "description": "Review code", "argumentHint": "<pr>"},
+			},
+			"output_style":            "default",
+			"available_output_styles": []any{"default", "Explanatory"},
+			"models": []any{
+				map[string]any{"value": "sonnet", "displayName": "Sonnet", "description": "Fast"},
+			},
+		}
+		client := NewClient(WithTransport(mt))
+		if err := client.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Close()
+
+		info := client.ServerInfo()
+		if info == nil {
+			t.Fatal("ServerInfo() = nil after Connect")
+		}
+		want := SlashCommand{Name: "review", Description: "Review code", ArgumentHint: "<pr>"}
+		if len(info.Commands) != 1 || info.Commands[0] != want {
+			t.Errorf("Commands = %+v, want [%+v]", info.Commands, want)
+		}
+		if info.OutputStyle != "default" || len(info.OutputStyles) != 2 {
+			t.Errorf("OutputStyle = %q, OutputStyles = %v", info.OutputStyle, info.OutputStyles)
+		}
+		if len(info.Models) != 1 || info.Models[0].DisplayName != "Sonnet" {
+			t.Errorf("Models = %+v, want Sonnet", info.Models)
+		}
+	})
+
+	t.Run("init system message fills session details", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		mt.QueueMessage([]byte(`{"type":"system","subtype":"init","session_id":"sess-1","tools":["Bash","Read"],` +
+			`"mcp_servers":[{"name":"calc","status":"connected"}],"model":"claude-sonnet-4-5","cwd":"/work",` +
+			`"claude_code_version":"2.0.1","permissionMode":"default","apiKeySource":"none","slash_commands":["compact"]}`))
+		mt.CloseMessages()
+		for range client.Messages() {
+		}
+
+		info := client.ServerInfo()
+		if info.SessionID != "sess-1" || info.Model != "claude-sonnet-4-5" || info.Cwd != "/work" || info.CLIVersion != "2.0.1" {
+			t.Errorf("ServerInfo() = %+v, want session details from init message", info)
+		}
+		if len(info.Tools) != 2 || info.Tools[1] != "Read" {
+			t.Errorf("Tools = %v, want [Bash Read]", info.Tools)
+		}
+		if len(info.MCPServers) != 1 || info.MCPServers[0] != (MCPServerStatus{Name: "calc", Status: "connected"}) {
+			t.Errorf("MCPServers = %+v, want calc connected", info.MCPServers)
+		}
+		if info.PermissionMode != PermissionDefault || info.APIKeySource != "none" {
+			t.Errorf("PermissionMode = %q, APIKeySource = %q", info.PermissionMode, info.APIKeySource)
+		}
+		if len(info.Commands) != 1 || info.Commands[0].Name != "compact" {
+			t.Errorf("Commands = %+v, want compact from slash_commands", info.Commands)
+		}
+	})
+
+	t.Run("initialize response keeps an init message that arrived first", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.initResponse = map[string]any{
+			"commands": []any{map[string]any{"name": "review", "description": "Review code"}},
+		}
+		mt.QueueMessage([]byte(`{"type":"system","subtype":"init","session_id":"sess-1","tools":["Bash"],` +
+			`"model":"claude-sonnet-4-5","cwd":"/work","slash_commands":["compact"]}`))
+		client := NewClient(WithTransport(mt))
+		if err := client.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Close()
+
+		info := client.ServerInfo()
+		if info.SessionID != "sess-1" || info.Model != "claude-sonnet-4-5" || info.Cwd != "/work" || len(info.Tools) != 1 {
+			t.Errorf("ServerInfo() = %+v, want session details from init message", info)
+		}
+		if len(info.Commands) != 1 || info.Commands[0].Description != "Review code" {
+			t.Errorf("Commands = %+v, want review from the initialize response", info.Commands)
+		}
+	})
+
+	t.Run("Connect fails when CLI does not answer initialize", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.ignoreControl = true
+		client := NewClient(WithTransport(mt), WithInitializeTimeout(20*time.Millisecond))
+
+		err := client.Connect(context.Background())
+
+		if !errors.Is(err, ErrCLIConnection) {
+			t.Errorf("Connect() error = %v, want %v", err, ErrCLIConnection)
+		}
+		if client.IsConnected() {
+			t.Error("IsConnected() = true after failed handshake")
+		}
+	})
+
+	t.Run("Connect reports why the CLI exited during the handshake", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.ignoreControl = true
+		mt.QueueError(&ProcessError{ExitCode: 2, Stderr: "bad flag"})
+		mt.CloseMessages()
+		client := NewClient(WithTransport(mt))
+
+		err := client.Connect(context.Background())
+
+		var procErr *ProcessError
+		if !errors.As(err, &procErr) || procErr.ExitCode != 2 {
+			t.Errorf("Connect() error = %v, want *ProcessError with exit code 2", err)
+		}
+	})
+}
+
 func TestClient_GetServerInfo(t *testing.T) {
 	t.Run("returns nil when no server info captured", func(t *testing.T) {
 		client := NewClient()
@@ -1103,9 +2175,15 @@ func TestParseStreamEvent(t *testing.T) {
 		if se.Event == nil {
 			t.Fatal("Event should not be nil")
 		}
-		eventType, _ := se.Event["type"].(string)
-		if eventType != "content_block_delta" {
-			t.Errorf("Event[type] = %q, want 'content_block_delta'", eventType)
+		var event map[string]any
+		if err := json.Unmarshal(se.Event, &event); err != nil || event["type"] != "content_block_delta" {
+			t.Errorf("Event = %s, want the content_block_delta event", se.Event)
+		}
+		if se.Type != StreamContentBlockDelta || se.Index != 0 {
+			t.Errorf("Type = %q, Index = %d, want content_block_delta at 0", se.Type, se.Index)
+		}
+		if se.Delta == nil || se.Delta.Type != DeltaText || se.Delta.Text != "Hello" {
+			t.Errorf("Delta = %+v, want text_delta 'Hello'", se.Delta)
 		}
 	})
 
@@ -1207,3 +2285,134 @@ func TestClientRewindFiles(t *testing.T) {
 		}
 	})
 }
+
+func TestClientTurn(t *testing.T) {
+	t.Run("sends prompt and yields messages through the result", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+		queueJSON(t, mt,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"one"}],"model":"m"}}`,
+			testResultJSON,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"next turn"}],"model":"m"}}`,
+		)
+
+		var received []Message
+		for msg, err := range client.Turn(context.Background(), "hello") {
+			if err != nil {
+				t.Fatalf("Turn() error = %v", err)
+			}
+			received = append(received, msg)
+		}
+
+		if len(received) != 2 {
+			t.Fatalf("received %d messages, want 2", len(received))
+		}
+		if _, ok := received[1].(*ResultMessage); !ok {
+			t.Errorf("last message = %T, want *ResultMessage", received[1])
+		}
+		if len(mt.sentMessages) != 1 || !strings.Contains(string(mt.sentMessages[0]), "hello") {
+			t.Errorf("sentMessages = %q, want the prompt", mt.sentMessages)
+		}
+		if !client.IsConnected() {
+			t.Error("client should stay connected")
+		}
+	})
+
+	t.Run("interrupts and discards the rest of the turn on break", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			mt := newMockTransport()
+			client := NewClient(WithTransport(mt))
+			_ = client.Connect(context.Background())
+			queueJSON(t, mt,
+				`{"type":"assistant","message":{"content":[{"type":"text","text":"one"}],"model":"m"}}`,
+				`{"type":"assistant","message":{"content":[{"type":"text","text":"two"}],"model":"m"}}`,
+				testResultJSON,
+				`{"type":"assistant","message":{"content":[{"type":"text","text":"next turn"}],"model":"m"}}`,
+			)
+
+			for range client.Turn(context.Background(), "hello") {
+				break
+			}
+			synctest.Wait()
+
+			if len(mt.sentMessages) != 2 || !strings.Contains(string(mt.sentMessages[1]), "interrupt") {
+				t.Errorf("sentMessages = %q, want prompt and interrupt", mt.sentMessages)
+			}
+			next, ok := (<-client.Messages()).(*AssistantMessage)
+			if !ok || next.Content[0].Text != "next turn" {
+				t.Errorf("next message = %+v, want the next turn's message", next)
+			}
+
+			_ = client.Close()
+			close(mt.messagesCh)
+			synctest.Wait()
+		})
+	})
+
+	t.Run("yields the error that ended the session", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+		mt.QueueError(&ProcessError{ExitCode: 1})
+		mt.CloseMessages()
+
+		var last error
+		for _, err := range client.Turn(context.Background(), "hello") {
+			last = err
+		}
+
+		var procErr *ProcessError
+		if !errors.As(last, &procErr) {
+			t.Errorf("last error = %v, want *ProcessError", last)
+		}
+	})
+
+	t.Run("yields error when not connected", func(t *testing.T) {
+		client := NewClient()
+
+		var errs []error
+		for _, err := range client.Turn(context.Background(), "hello") {
+			errs = append(errs, err)
+		}
+
+		if len(errs) != 1 || !errors.Is(errs[0], ErrNotConnected) {
+			t.Errorf("errors = %v, want [%v]", errs, ErrNotConnected)
+		}
+	})
+}
+
+// BenchmarkParseMessage measures decoding lines from the CLI. A large
+// tool_result takes about as long as with map decoding: the time goes to
+// unescaping its content string, which any decoding into Go values must do.
+func BenchmarkParseMessage(b *testing.B) {
+	largeOutput, _ := json.Marshal(strings.Repeat("line of Read tool output\n", 10000))
+	lines := []struct {
+		name string
+		data string
+	}{
+		{"stream_event", `{"type":"stream_event","uuid":"5c1d7a2e-4f8b-4a8e-9d3c-2b1e6f0a7c94","session_id":"8f2b1c3d-6e4a-4b5c-9d7e-1a2b3c4d5e6f",` +
+			`"event":{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello, world"}},"parent_tool_use_id":null}`},
+		{"assistant", `{"type":"assistant","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-sonnet-4-5",` +
+			`"content":[{"type":"text","text":"Let me read that file."},{"type":"tool_use","id":"toolu_01","name":"Read","input":{"file_path":"/src/main.go"}}],` +
+			`"stop_reason":"tool_use","usage":{"input_tokens":1200,"output_tokens":80,"cache_read_input_tokens":9000}},"parent_tool_use_id":null,"session_id":"s"}`},
+		{"tool_result", `{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_01","content":` +
+			string(largeOutput) + `}]},"parent_tool_use_id":null,"session_id":"s","uuid":"u"}`},
+	}
+
+	for _, line := range lines {
+		b.Run(line.name, func(b *testing.B) {
+			client := NewClient()
+			data := []byte(line.data)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := client.parseMessage(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,12 +1,13 @@
 package claude
 
+import "encoding/json"
+
 // ContentBlockKind discriminates the type of content in a ContentBlock.
 //
 // Design rationale: using a single struct with Kind discriminator (Genkit pattern)
 // rather than separate types with an interface because:
-//   - JSON marshaling is trivial with struct tags.
-//   - All 4 variants have similar sizes, minimal memory waste.
-//   - Avoids custom UnmarshalJSON complexity for discriminated unions.
+//   - One MarshalJSON/UnmarshalJSON pair covers every variant.
+//   - All variants have similar sizes, minimal memory waste.
 //   - Helper methods (IsText, IsToolUse, etc.) provide type checking.
 type ContentBlockKind int8
 
@@ -22,29 +23,228 @@ const (
 
 	// BlockToolResult represents the result of a tool invocation.
 	BlockToolResult
+
+	// BlockRedactedThinking represents thinking content encrypted by the
+	// API for safety reasons.
+	BlockRedactedThinking
+
+	// BlockServerToolUse represents a server-side tool invocation, such as
+	// web search.
+	BlockServerToolUse
+
+	// BlockWebSearchToolResult represents the results of a web search.
+	BlockWebSearchToolResult
+
+	// BlockImage represents an image.
+	BlockImage
+
+	// BlockDocument represents a document, such as a PDF.
+	BlockDocument
+
+	// BlockUnknown represents a block type this SDK does not recognize.
+	// Its original JSON is kept in Raw.
+	BlockUnknown
 )
 
 // ContentBlock represents a block of content in a message.
 // Use the Kind field to determine which fields are relevant,
 // or use the Is*() helper methods.
+//
+// ContentBlock marshals to and from the Anthropic API's content block
+// format, such as {"type":"text","text":"Hello"}. Blocks of unknown type
+// keep their original JSON in Raw and marshal back to it unchanged.
 type ContentBlock struct {
-	Kind ContentBlockKind `json:"kind"`
+	Kind ContentBlockKind
 
 	// Text content (Kind == BlockText)
-	Text string `json:"text,omitempty"`
+	Text string
 
 	// Thinking content (Kind == BlockThinking)
-	Thinking  string `json:"thinking,omitempty"`
-	Signature string `json:"signature,omitempty"`
+	Thinking  string
+	Signature string
+
+	// Encrypted thinking (Kind == BlockRedactedThinking)
+	Data string
 
-	// Tool use fields (Kind == BlockToolUse or BlockToolResult)
-	ToolUseID string         `json:"id,omitempty"`
-	ToolName  string         `json:"name,omitempty"`
-	ToolInput map[string]any `json:"input,omitempty"`
+	// Tool use fields (Kind == BlockToolUse, BlockServerToolUse,
+	// BlockToolResult or BlockWebSearchToolResult)
+	ToolUseID string
+	ToolName  string
+	ToolInput map[string]any
 
-	// Tool result fields (Kind == BlockToolResult)
-	ToolResult any  `json:"content,omitempty"`
-	IsError    bool `json:"is_error,omitempty"`
+	// Tool result fields (Kind == BlockToolResult or BlockWebSearchToolResult)
+	ToolResult any
+	IsError    bool
+
+	// Media fields (Kind == BlockImage or BlockDocument)
+	Source *ContentSource
+	Title  string
+
+	// Original JSON (Kind == BlockUnknown)
+	Raw json.RawMessage
+}
+
+// blockTypes maps each block kind to its type name in the Anthropic API.
+var blockTypes = map[ContentBlockKind]string{
+	BlockText:                "text",
+	BlockThinking:            "thinking",
+	BlockToolUse:             "tool_use",
+	BlockToolResult:          "tool_result",
+	BlockRedactedThinking:    "redacted_thinking",
+	BlockServerToolUse:       "server_tool_use",
+	BlockWebSearchToolResult: "web_search_tool_result",
+	BlockImage:               "image",
+	BlockDocument:            "document",
+}
+
+// blockKinds is the inverse of blockTypes.
+var blockKinds = func() map[string]ContentBlockKind {
+	kinds := make(map[string]ContentBlockKind, len(blockTypes))
+	for kind, name := range blockTypes {
+		kinds[name] = kind
+	}
+	return kinds
+}()
+
+// String returns the kind's type name in the Anthropic API, such as
+// "tool_use", or "unknown".
+func (k ContentBlockKind) String() string {
+	if name, ok := blockTypes[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// contentBlockJSON is the wire format of a content block. The pointer
+// fields are required by some block types even when empty.
+type contentBlockJSON struct {
+	Type      string         `json:"type"`
+	Text      *string        `json:"text,omitempty"`
+	Thinking  *string        `json:"thinking,omitempty"`
+	Signature *string        `json:"signature,omitempty"`
+	Data      string         `json:"data,omitempty"`
+	ID        string         `json:"id,omitempty"`
+	ToolUseID string         `json:"tool_use_id,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Input     any            `json:"input,omitempty"`
+	Content   any            `json:"content,omitempty"`
+	IsError   bool           `json:"is_error,omitempty"`
+	Source    *ContentSource `json:"source,omitempty"`
+	Title     string         `json:"title,omitempty"`
+
+	// Kind is read from the format used by earlier versions of this
+	// package, which had a numeric kind instead of a type.
+	Kind *ContentBlockKind `json:"kind,omitempty"`
+}
+
+// MarshalJSON encodes the block in the Anthropic API format.
+func (b ContentBlock) MarshalJSON() ([]byte, error) {
+	if b.Kind == BlockUnknown && len(b.Raw) > 0 {
+		return b.Raw, nil
+	}
+
+	out := contentBlockJSON{Type: b.Kind.String()}
+	switch b.Kind {
+	case BlockText:
+		out.Text = &b.Text
+	case BlockThinking:
+		out.Thinking = &b.Thinking
+		out.Signature = &b.Signature
+	case BlockRedactedThinking:
+		out.Data = b.Data
+	case BlockToolUse, BlockServerToolUse:
+		out.ID = b.ToolUseID
+		out.Name = b.ToolName
+		out.Input = b.ToolInput
+		if b.ToolInput == nil {
+			out.Input = map[string]any{}
+		}
+	case BlockToolResult:
+		out.ToolUseID = b.ToolUseID
+		out.Content = b.ToolResult
+		out.IsError = b.IsError
+	case BlockWebSearchToolResult:
+		out.ToolUseID = b.ToolUseID
+		out.Content = b.ToolResult
+	case BlockImage:
+		out.Source = b.Source
+	case BlockDocument:
+		out.Source = b.Source
+		out.Title = b.Title
+	case BlockUnknown:
+		// Without its original JSON an unknown block has only its type.
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a block in the Anthropic API format. A block of
+// unrecognized type becomes a BlockUnknown block with its JSON in Raw.
+func (b *ContentBlock) UnmarshalJSON(data []byte) error {
+	var in contentBlockJSON
+	if err := decodeJSON(data, &in); err != nil {
+		return err
+	}
+
+	block, ok := in.block()
+	if !ok {
+		block = &ContentBlock{Kind: BlockUnknown, Raw: append(json.RawMessage(nil), data...)}
+	}
+	*b = *block
+	return nil
+}
+
+// block converts a decoded block. It returns false if the block's type
+// is not recognized.
+func (in *contentBlockJSON) block() (*ContentBlock, bool) {
+	kind, ok := blockKinds[in.Type]
+	switch {
+	case ok:
+	case in.Type == "" && in.Kind != nil:
+		kind = *in.Kind
+	default:
+		return nil, false
+	}
+
+	b := &ContentBlock{
+		Kind:       kind,
+		Data:       in.Data,
+		ToolUseID:  in.ID,
+		ToolName:   in.Name,
+		ToolResult: in.Content,
+		IsError:    in.IsError,
+		Source:     in.Source,
+		Title:      in.Title,
+	}
+	if in.Text != nil {
+		b.Text = *in.Text
+	}
+	if in.Thinking != nil {
+		b.Thinking = *in.Thinking
+	}
+	if in.Signature != nil {
+		b.Signature = *in.Signature
+	}
+	if in.ToolUseID != "" {
+		b.ToolUseID = in.ToolUseID
+	}
+	b.ToolInput, _ = in.Input.(map[string]any)
+	return b, true
+}
+
+// ContentSource is the source of an image or document block.
+type ContentSource struct {
+	// Type is the source type: "base64", "url" or "text".
+	Type string `json:"type"`
+
+	// MediaType is the MIME type, such as "image/png" (Type == "base64"
+	// or "text").
+	MediaType string `json:"media_type,omitempty"`
+
+	// Data is the base64-encoded or plain text content.
+	Data string `json:"data,omitempty"`
+
+	// URL is the location of the content (Type == "url").
+	URL string `json:"url,omitempty"`
 }
 
 // IsText returns true if this is a text content block.
@@ -67,6 +267,36 @@ func (b *ContentBlock) IsToolResult() bool {
 	return b.Kind == BlockToolResult
 }
 
+// IsRedactedThinking returns true if this is a redacted thinking block.
+func (b *ContentBlock) IsRedactedThinking() bool {
+	return b.Kind == BlockRedactedThinking
+}
+
+// IsServerToolUse returns true if this is a server tool use block.
+func (b *ContentBlock) IsServerToolUse() bool {
+	return b.Kind == BlockServerToolUse
+}
+
+// IsWebSearchToolResult returns true if this is a web search result block.
+func (b *ContentBlock) IsWebSearchToolResult() bool {
+	return b.Kind == BlockWebSearchToolResult
+}
+
+// IsImage returns true if this is an image block.
+func (b *ContentBlock) IsImage() bool {
+	return b.Kind == BlockImage
+}
+
+// IsDocument returns true if this is a document block.
+func (b *ContentBlock) IsDocument() bool {
+	return b.Kind == BlockDocument
+}
+
+// IsUnknown returns true if this block's type was not recognized.
+func (b *ContentBlock) IsUnknown() bool {
+	return b.Kind == BlockUnknown
+}
+
 // NewTextBlock creates a new text content block.
 func NewTextBlock(text string) *ContentBlock {
 	return &ContentBlock{
@@ -103,3 +333,20 @@ func NewToolResultBlock(toolUseID string, result any, isError bool) *ContentBloc
 		IsError:    isError,
 	}
 }
+
+// NewImageBlock creates a new image block.
+func NewImageBlock(source *ContentSource) *ContentBlock {
+	return &ContentBlock{
+		Kind:   BlockImage,
+		Source: source,
+	}
+}
+
+// NewDocumentBlock creates a new document block.
+func NewDocumentBlock(source *ContentSource, title string) *ContentBlock {
+	return &ContentBlock{
+		Kind:   BlockDocument,
+		Source: source,
+		Title:  title,
+	}
+}
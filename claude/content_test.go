@@ -7,7 +7,11 @@ import (
 
 func TestContentBlockKind(t *testing.T) {
 	t.Run("kind constants are distinct", func(t *testing.T) {
-		kinds := []ContentBlockKind{BlockText, BlockThinking, BlockToolUse, BlockToolResult}
+		kinds := []ContentBlockKind{
+			BlockText, BlockThinking, BlockToolUse, BlockToolResult,
+			BlockRedactedThinking, BlockServerToolUse, BlockWebSearchToolResult,
+			BlockImage, BlockDocument, BlockUnknown,
+		}
 		seen := make(map[ContentBlockKind]bool)
 		for _, k := range kinds {
 			if seen[k] {
@@ -170,17 +174,78 @@ func TestContentBlock_JSON(t *testing.T) {
 			t.Fatalf("Marshal failed: %v", err)
 		}
 
-		var parsed map[string]any
-		if err := json.Unmarshal(data, &parsed); err != nil {
+		if want := `{"type":"text","text":"Hello"}`; string(data) != want {
+			t.Errorf("JSON = %s, want %s", data, want)
+		}
+	})
+
+	t.Run("marshals each kind in the API format", func(t *testing.T) {
+		source := &ContentSource{Type: "base64", MediaType: "image/png", Data: "aGk="}
+		tests := []struct {
+			block *ContentBlock
+			want  string
+		}{
+			{NewTextBlock(""), `{"type":"text","text":""}`},
+			{NewThinkingBlock("hmm", "sig"), `{"type":"thinking","thinking":"hmm","signature":"sig"}`},
+			{&ContentBlock{Kind: BlockRedactedThinking, Data: "enc"}, `{"type":"redacted_thinking","data":"enc"}`},
+			{NewToolUseBlock("tool-1", "Read", map[string]any{"file_path": "/a"}), `{"type":"tool_use","id":"tool-1","name":"Read","input":{"file_path":"/a"}}`},
+			{NewToolUseBlock("tool-1", "Bash", nil), `{"type":"tool_use","id":"tool-1","name":"Bash","input":{}}`},
+			{&ContentBlock{Kind: BlockServerToolUse, ToolUseID: "srv-1", ToolName: "web_search"}, `{"type":"server_tool_use","id":"srv-1","name":"web_search","input":{}}`},
+			{NewToolResultBlock("tool-1", "ok", true), `{"type":"tool_result","tool_use_id":"tool-1","content":"ok","is_error":true}`},
+			{&ContentBlock{Kind: BlockWebSearchToolResult, ToolUseID: "srv-1", ToolResult: []any{}}, `{"type":"web_search_tool_result","tool_use_id":"srv-1","content":[]}`},
+			{NewImageBlock(source), `{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aGk="}}`},
+			{NewDocumentBlock(source, "doc"), `{"type":"document","source":{"type":"base64","media_type":"image/png","data":"aGk="},"title":"doc"}`},
+			{&ContentBlock{Kind: BlockUnknown, Raw: json.RawMessage(`{"type":"mystery","x":1}`)}, `{"type":"mystery","x":1}`},
+		}
+
+		for _, tt := range tests {
+			data, err := json.Marshal(tt.block)
+			if err != nil {
+				t.Fatalf("Marshal(%v) error = %v", tt.block.Kind, err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Marshal(%v) = %s, want %s", tt.block.Kind, data, tt.want)
+			}
+
+			var decoded ContentBlock
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal(%s) error = %v", data, err)
+			}
+			if again, _ := json.Marshal(&decoded); string(again) != tt.want {
+				t.Errorf("round trip of %s = %s", tt.want, again)
+			}
+		}
+	})
+
+	t.Run("unmarshal tool result block from JSON", func(t *testing.T) {
+		jsonData := `{"type":"tool_result","tool_use_id":"tool-123","content":[{"type":"text","text":"out"}]}`
+
+		var block ContentBlock
+		if err := json.Unmarshal([]byte(jsonData), &block); err != nil {
 			t.Fatalf("Unmarshal failed: %v", err)
 		}
 
-		// Should have kind field
-		if _, ok := parsed["kind"]; !ok {
-			t.Error("JSON should have 'kind' field")
+		if block.Kind != BlockToolResult {
+			t.Errorf("Kind = %v, want BlockToolResult", block.Kind)
+		}
+		if block.ToolUseID != "tool-123" {
+			t.Errorf("ToolUseID = %q, want 'tool-123'", block.ToolUseID)
+		}
+		if content, ok := block.ToolResult.([]any); !ok || len(content) != 1 {
+			t.Errorf("ToolResult = %v, want one content item", block.ToolResult)
+		}
+	})
+
+	t.Run("unmarshal unknown block keeps its JSON", func(t *testing.T) {
+		jsonData := `{"type":"container_upload","file_id":"f1"}`
+
+		var block ContentBlock
+		if err := json.Unmarshal([]byte(jsonData), &block); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
 		}
-		if parsed["text"] != "Hello" {
-			t.Errorf("JSON text = %v, want 'Hello'", parsed["text"])
+
+		if !block.IsUnknown() || string(block.Raw) != jsonData {
+			t.Errorf("block = %+v, want unknown block with Raw %s", block, jsonData)
 		}
 	})
 
@@ -271,3 +336,51 @@ func TestContentBlockConstructors(t *testing.T) {
 		}
 	})
 }
+
+func TestContentBlock_MediaBlocks(t *testing.T) {
+	t.Run("NewImageBlock creates image block", func(t *testing.T) {
+		source := &ContentSource{Type: "base64", MediaType: "image/jpeg", Data: "abc"}
+		block := NewImageBlock(source)
+
+		if !block.IsImage() {
+			t.Error("IsImage() should return true for image block")
+		}
+		if block.IsDocument() {
+			t.Error("IsDocument() should return false for image block")
+		}
+		if block.Source != source {
+			t.Error("Source not set")
+		}
+	})
+
+	t.Run("NewDocumentBlock creates document block", func(t *testing.T) {
+		block := NewDocumentBlock(&ContentSource{Type: "url", URL: "https://example.com/a.pdf"}, "A")
+
+		if !block.IsDocument() {
+			t.Error("IsDocument() should return true for document block")
+		}
+		if block.Title != "A" {
+			t.Errorf("Title = %q, want 'A'", block.Title)
+		}
+	})
+
+	t.Run("other helpers match their kinds", func(t *testing.T) {
+		checks := []struct {
+			kind ContentBlockKind
+			is   func(*ContentBlock) bool
+		}{
+			{BlockRedactedThinking, (*ContentBlock).IsRedactedThinking},
+			{BlockServerToolUse, (*ContentBlock).IsServerToolUse},
+			{BlockWebSearchToolResult, (*ContentBlock).IsWebSearchToolResult},
+			{BlockUnknown, (*ContentBlock).IsUnknown},
+		}
+		for _, check := range checks {
+			if !check.is(&ContentBlock{Kind: check.kind}) {
+				t.Errorf("helper for kind %d returned false", check.kind)
+			}
+			if check.is(&ContentBlock{Kind: BlockText}) {
+				t.Errorf("helper for kind %d returned true for text block", check.kind)
+			}
+		}
+	})
+}
@@ -11,6 +11,17 @@ type ControlRequestSubtype string
 // MessageTypeControlRequest is the type field value for control requests.
 const MessageTypeControlRequest = "control_request"
 
+// MessageTypeControlResponse is the type field value for control responses.
+const MessageTypeControlResponse = "control_response"
+
+const (
+	// ControlResponseSuccess is the subtype of a successful control response.
+	ControlResponseSuccess = "success"
+
+	// ControlResponseError is the subtype of a failed control response.
+	ControlResponseError = "error"
+)
+
 const (
 	// ControlSubtypeInterrupt sends an interrupt signal.
 	ControlSubtypeInterrupt ControlRequestSubtype = "interrupt"
@@ -49,10 +60,10 @@ type ControlRequestBody struct {
 	Subtype ControlRequestSubtype `json:"subtype"`
 
 	// For can_use_tool
-	ToolName              string         `json:"tool_name,omitempty"`
-	Input                 map[string]any `json:"input,omitempty"`
-	PermissionSuggestions []any          `json:"permission_suggestions,omitempty"`
-	BlockedPath           string         `json:"blocked_path,omitempty"`
+	ToolName              string             `json:"tool_name,omitempty"`
+	Input                 map[string]any     `json:"input,omitempty"`
+	PermissionSuggestions []PermissionUpdate `json:"permission_suggestions,omitempty"`
+	BlockedPath           string             `json:"blocked_path,omitempty"`
 
 	// For initialize (use InitHookDefs for the actual hook config sent to CLI)
 	Hooks        map[HookEvent][]HookDefinition    `json:"-"`
@@ -105,11 +116,14 @@ type ControlResponsePayload struct {
 }
 
 // PermissionResultResponse is the response to a can_use_tool request.
+// An allow response always carries UpdatedInput, even if it is empty;
+// omitzero leaves it out only when nil, as in a deny response.
 type PermissionResultResponse struct {
-	Behavior     string         `json:"behavior"`
-	Message      string         `json:"message,omitempty"`
-	Interrupt    bool           `json:"interrupt,omitempty"`
-	UpdatedInput map[string]any `json:"updated_input,omitempty"`
+	Behavior           string             `json:"behavior"`
+	Message            string             `json:"message,omitempty"`
+	Interrupt          bool               `json:"interrupt,omitempty"`
+	UpdatedInput       map[string]any     `json:"updatedInput,omitzero"`
+	UpdatedPermissions []PermissionUpdate `json:"updatedPermissions,omitempty"`
 }
 
 // HookCallbackResponse is the response to a hook_callback request.
@@ -208,12 +222,24 @@ func NewSetModelRequest(model string) *ControlRequest {
 	}
 }
 
+// NewRewindFilesRequest creates a rewind files request.
+func NewRewindFilesRequest(userMessageID string) *ControlRequest {
+	return &ControlRequest{
+		Type:      MessageTypeControlRequest,
+		RequestID: generateRequestID(),
+		Request: &ControlRequestBody{
+			Subtype:       ControlSubtypeRewindFiles,
+			UserMessageID: userMessageID,
+		},
+	}
+}
+
 // NewControlResponseSuccess creates a success response.
 func NewControlResponseSuccess(requestID string, response any) *ControlResponse {
 	return &ControlResponse{
-		Type: "control_response",
+		Type: MessageTypeControlResponse,
 		Response: &ControlResponsePayload{
-			Subtype:   "success",
+			Subtype:   ControlResponseSuccess,
 			RequestID: requestID,
 			Response:  response,
 		},
@@ -223,9 +249,9 @@ func NewControlResponseSuccess(requestID string, response any) *ControlResponse
 // NewControlResponseError creates an error response.
 func NewControlResponseError(requestID string, errMsg string) *ControlResponse {
 	return &ControlResponse{
-		Type: "control_response",
+		Type: MessageTypeControlResponse,
 		Response: &ControlResponsePayload{
-			Subtype:   "error",
+			Subtype:   ControlResponseError,
 			RequestID: requestID,
 			Error:     errMsg,
 		},
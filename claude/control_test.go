@@ -344,12 +344,12 @@ func TestPermissionResultResponse(t *testing.T) {
 			t.Fatalf("Unmarshal error: %v", err)
 		}
 
-		updatedInput, ok := result["updated_input"].(map[string]any)
+		updatedInput, ok := result["updatedInput"].(map[string]any)
 		if !ok {
-			t.Fatal("updated_input should be a map")
+			t.Fatal("updatedInput should be a map")
 		}
 		if updatedInput["command"] != "ls -la" {
-			t.Errorf("updated_input.command = %v, want 'ls -la'", updatedInput["command"])
+			t.Errorf("updatedInput.command = %v, want 'ls -la'", updatedInput["command"])
 		}
 	})
 }
@@ -707,3 +707,22 @@ func TestControlRequestRewindFiles(t *testing.T) {
 		}
 	})
 }
+
+func TestNewRewindFilesRequest(t *testing.T) {
+	t.Run("creates rewind_files request", func(t *testing.T) {
+		req := NewRewindFilesRequest("msg-uuid-1")
+
+		if req.Type != MessageTypeControlRequest {
+			t.Errorf("Type = %q, want %q", req.Type, MessageTypeControlRequest)
+		}
+		if req.RequestID == "" {
+			t.Error("RequestID should not be empty")
+		}
+		if req.Request.Subtype != ControlSubtypeRewindFiles {
+			t.Errorf("Subtype = %q, want %q", req.Request.Subtype, ControlSubtypeRewindFiles)
+		}
+		if req.Request.UserMessageID != "msg-uuid-1" {
+			t.Errorf("UserMessageID = %q, want 'msg-uuid-1'", req.Request.UserMessageID)
+		}
+	})
+}
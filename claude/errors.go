@@ -16,8 +16,47 @@ var (
 
 	// ErrCLIConnection indicates a failure to connect to the CLI process.
 	ErrCLIConnection = errors.New("claude: CLI connection failed")
+
+	// ErrMaxTurns indicates a query stopped after reaching WithMaxTurns.
+	ErrMaxTurns = errors.New("claude: maximum turns reached")
+
+	// ErrBudgetExceeded indicates a query stopped after reaching
+	// WithMaxBudgetUSD.
+	ErrBudgetExceeded = errors.New("claude: budget exceeded")
+
+	// ErrExecution indicates the CLI hit an error while running a query,
+	// such as an interrupted turn, or ended it with an error result the
+	// SDK does not recognize.
+	ErrExecution = errors.New("claude: error during execution")
+)
+
+// APIErrorKind classifies an Anthropic API failure.
+type APIErrorKind string
+
+// API error kinds reported by the CLI.
+const (
+	APIErrorAuthenticationFailed APIErrorKind = "authentication_failed"
+	APIErrorBilling              APIErrorKind = "billing_error"
+	APIErrorRateLimit            APIErrorKind = "rate_limit"
+	APIErrorInvalidRequest       APIErrorKind = "invalid_request"
+	APIErrorServer               APIErrorKind = "server_error"
+	APIErrorUnknown              APIErrorKind = "unknown"
 )
 
+// APIError represents a failed Anthropic API request, such as a rate limit
+// or an invalid API key. Use errors.As() to extract this from wrapped errors.
+type APIError struct {
+	Kind    APIErrorKind
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("claude: API error (%s): %s", e.Kind, e.Message)
+	}
+	return fmt.Sprintf("claude: API error (%s)", e.Kind)
+}
+
 // ProcessError represents a CLI process failure with exit code and stderr output.
 // Use errors.As() to extract this from wrapped errors.
 type ProcessError struct {
@@ -32,6 +71,18 @@ func (e *ProcessError) Error() string {
 	return fmt.Sprintf("claude: process exited with code %d", e.ExitCode)
 }
 
+// ControlError represents an error response from the CLI to a control request.
+// Use errors.As() to extract this from wrapped errors.
+type ControlError struct {
+	RequestID string
+	Subtype   ControlRequestSubtype
+	Message   string
+}
+
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("claude: control request %s failed: %s", e.Subtype, e.Message)
+}
+
 // JSONDecodeError represents a failure to parse JSON from the CLI.
 // Wraps the original json error and includes the problematic line.
 type JSONDecodeError struct {
@@ -47,3 +98,15 @@ func (e *JSONDecodeError) Error() string {
 func (e *JSONDecodeError) Unwrap() error {
 	return e.OriginalError
 }
+
+// MessageTooLargeError reports a message from the CLI that was skipped
+// because it is longer than the limit set with WithMaxBufferSize. The
+// session continues without it.
+type MessageTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("claude: skipped message of %d bytes, over the %d byte limit", e.Size, e.Limit)
+}
@@ -3,6 +3,7 @@ package claude
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -104,6 +105,33 @@ func TestProcessError(t *testing.T) {
 	})
 }
 
+func TestControlError(t *testing.T) {
+	t.Run("error message includes subtype and message", func(t *testing.T) {
+		err := &ControlError{
+			RequestID: "req-1",
+			Subtype:   ControlSubtypeSetModel,
+			Message:   "unknown model",
+		}
+
+		msg := err.Error()
+		if !contains(msg, "set_model") {
+			t.Errorf("Error() = %q, should contain subtype", msg)
+		}
+		if !contains(msg, "unknown model") {
+			t.Errorf("Error() = %q, should contain message", msg)
+		}
+	})
+
+	t.Run("works with errors.As", func(t *testing.T) {
+		var wrapped error = fmt.Errorf("wrap: %w", &ControlError{Message: "failed"})
+
+		var ce *ControlError
+		if !errors.As(wrapped, &ce) {
+			t.Error("errors.As should extract ControlError")
+		}
+	})
+}
+
 func TestJSONDecodeError(t *testing.T) {
 	t.Run("stores line and original error", func(t *testing.T) {
 		// Create a real JSON decode error
@@ -172,3 +200,40 @@ func searchString(s, substr string) bool {
 	}
 	return false
 }
+
+func TestAPIError(t *testing.T) {
+	t.Run("error message includes kind and message", func(t *testing.T) {
+		err := &APIError{Kind: APIErrorRateLimit, Message: "slow down"}
+
+		if err.Error() != "claude: API error (rate_limit): slow down" {
+			t.Errorf("Error() = %q", err.Error())
+		}
+	})
+
+	t.Run("error message without message", func(t *testing.T) {
+		err := &APIError{Kind: APIErrorServer}
+
+		if err.Error() != "claude: API error (server_error)" {
+			t.Errorf("Error() = %q", err.Error())
+		}
+	})
+
+	t.Run("can be extracted with errors.As", func(t *testing.T) {
+		wrapped := fmt.Errorf("query failed: %w", &APIError{Kind: APIErrorBilling})
+
+		var apiErr *APIError
+		if !errors.As(wrapped, &apiErr) || apiErr.Kind != APIErrorBilling {
+			t.Errorf("errors.As() = %v, want billing_error APIError", apiErr)
+		}
+	})
+}
+
+func TestMessageTooLargeError(t *testing.T) {
+	t.Run("error message includes size and limit", func(t *testing.T) {
+		err := &MessageTooLargeError{Size: 2048, Limit: 1024}
+
+		if err.Error() != "claude: skipped message of 2048 bytes, over the 1024 byte limit" {
+			t.Errorf("Error() = %q", err.Error())
+		}
+	})
+}
@@ -13,7 +13,13 @@ const (
 	HookDecisionAllow HookDecision = "allow"
 
 	// HookDecisionDeny explicitly denies the tool use.
+	// For events other than PreToolUse it is treated like HookDecisionBlock.
 	HookDecisionDeny HookDecision = "deny"
+
+	// HookDecisionBlock blocks the event: it rejects the prompt
+	// (UserPromptSubmit), feeds the reason back to Claude (PostToolUse),
+	// or keeps the agent running (Stop, SubagentStop).
+	HookDecisionBlock HookDecision = "block"
 )
 
 // HookContext provides context information to hook callbacks.
@@ -33,7 +39,7 @@ type HookContext struct {
 
 // HookOutput is the response from a hook callback.
 type HookOutput struct {
-	// Decision is the permission decision (allow/deny/none).
+	// Decision is the hook decision (allow/deny/block/none).
 	Decision HookDecision
 
 	// Reason explains the decision (shown to the model).
@@ -101,6 +107,10 @@ type StopInput struct {
 
 	// SessionID is the session identifier.
 	SessionID string
+
+	// StopHookActive is true when the agent is already continuing because
+	// of a Stop hook. Check it to avoid blocking the stop forever.
+	StopHookActive bool
 }
 
 // SubagentStopInput contains information when a subagent stops.
@@ -113,6 +123,10 @@ type SubagentStopInput struct {
 
 	// SessionID is the session identifier.
 	SessionID string
+
+	// StopHookActive is true when the subagent is already continuing
+	// because of a SubagentStop hook.
+	StopHookActive bool
 }
 
 // PreCompactInput contains information before conversation compaction.
@@ -122,6 +136,12 @@ type PreCompactInput struct {
 
 	// MessageCount is the number of messages in the conversation.
 	MessageCount int
+
+	// Trigger is what started the compaction ("manual" or "auto").
+	Trigger string
+
+	// CustomInstructions are the user's instructions for a manual compaction.
+	CustomInstructions string
 }
 
 // Hook function types for each event.
@@ -2,8 +2,10 @@ package claude
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -371,11 +373,11 @@ func TestHookInitialization(t *testing.T) {
 		defer client.Close()
 
 		// Check that an initialize request was sent
-		if len(mt.sentMessages) == 0 {
-			t.Fatal("no messages sent on connect")
+		if len(mt.initRequests) == 0 {
+			t.Fatal("no initialize request sent on connect")
 		}
 
-		initMsg := string(mt.sentMessages[0])
+		initMsg := string(mt.initRequests[0])
 		if !strings.Contains(initMsg, "control_request") {
 			t.Errorf("first message should be control_request, got: %s", initMsg)
 		}
@@ -621,3 +623,411 @@ func TestHookCallbackExecution(t *testing.T) {
 		}
 	})
 }
+
+// runHookCallback connects a client with opt, delivers a hook_callback for
+// hook_0 with the given input JSON, and returns the decoded hook response.
+func runHookCallback(t *testing.T, opt Option, input string) map[string]any {
+	t.Helper()
+
+	mt := newMockTransport()
+	client := NewClient(WithTransport(mt), opt)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Close()
+
+	controlRequest := `{"type":"control_request","request_id":"req-hook","request":{"subtype":"hook_callback","callback_id":"hook_0","input":` + input + `}}`
+	mt.QueueMessage([]byte(controlRequest))
+	mt.CloseMessages()
+
+	for range client.Messages() {
+	}
+
+	for _, msg := range mt.sentMessages {
+		var resp ControlResponse
+		if err := json.Unmarshal(msg, &resp); err != nil || resp.Type != MessageTypeControlResponse {
+			continue
+		}
+		if resp.Response.RequestID == "req-hook" {
+			body, _ := resp.Response.Response.(map[string]any)
+			return body
+		}
+	}
+
+	t.Fatal("control_response not found")
+	return nil
+}
+
+func TestHookEventDispatch(t *testing.T) {
+	t.Run("UserPromptSubmit hook receives prompt and can block", func(t *testing.T) {
+		var received *UserPromptSubmitInput
+		hook := func(ctx context.Context, input *UserPromptSubmitInput, hookCtx *HookContext) (*HookOutput, error) {
+			received = input
+			return &HookOutput{Decision: HookDecisionBlock, Reason: "no secrets", AdditionalContext: "ctx"}, nil
+		}
+
+		resp := runHookCallback(t, WithUserPromptSubmitHook(hook),
+			`{"hook_event_name":"UserPromptSubmit","session_id":"sess-1","prompt":"show me the password"}`)
+
+		if received == nil {
+			t.Fatal("hook was not called")
+		}
+		if received.Prompt != "show me the password" {
+			t.Errorf("Prompt = %q, want 'show me the password'", received.Prompt)
+		}
+		if received.SessionID != "sess-1" {
+			t.Errorf("SessionID = %q, want 'sess-1'", received.SessionID)
+		}
+		if resp["decision"] != "block" {
+			t.Errorf("decision = %v, want 'block'", resp["decision"])
+		}
+		if resp["reason"] != "no secrets" {
+			t.Errorf("reason = %v, want 'no secrets'", resp["reason"])
+		}
+		specific, _ := resp["hookSpecificOutput"].(map[string]any)
+		if specific["hookEventName"] != "UserPromptSubmit" || specific["additionalContext"] != "ctx" {
+			t.Errorf("hookSpecificOutput = %v, want UserPromptSubmit additional context", specific)
+		}
+	})
+
+	t.Run("Stop hook can block stopping", func(t *testing.T) {
+		var received *StopInput
+		hook := func(ctx context.Context, input *StopInput, hookCtx *HookContext) (*HookOutput, error) {
+			received = input
+			return &HookOutput{Decision: HookDecisionBlock, Reason: "tests still failing"}, nil
+		}
+
+		resp := runHookCallback(t, WithStopHook(hook),
+			`{"hook_event_name":"Stop","session_id":"sess-2","stop_hook_active":true}`)
+
+		if received == nil {
+			t.Fatal("hook was not called")
+		}
+		if !received.StopHookActive {
+			t.Error("StopHookActive should be true")
+		}
+		if resp["decision"] != "block" {
+			t.Errorf("decision = %v, want 'block'", resp["decision"])
+		}
+		if resp["reason"] != "tests still failing" {
+			t.Errorf("reason = %v, want 'tests still failing'", resp["reason"])
+		}
+		if _, ok := resp["hookSpecificOutput"]; ok {
+			t.Errorf("Stop response should not have hookSpecificOutput, got %v", resp)
+		}
+	})
+
+	t.Run("SubagentStop hook receives agent ID", func(t *testing.T) {
+		var received *SubagentStopInput
+		hook := func(ctx context.Context, input *SubagentStopInput, hookCtx *HookContext) (*HookOutput, error) {
+			received = input
+			return &HookOutput{}, nil
+		}
+
+		resp := runHookCallback(t, WithSubagentStopHook(hook),
+			`{"hook_event_name":"SubagentStop","session_id":"sess-3","agent_id":"agent-7","stop_hook_active":false}`)
+
+		if received == nil {
+			t.Fatal("hook was not called")
+		}
+		if received.SubagentID != "agent-7" {
+			t.Errorf("SubagentID = %q, want 'agent-7'", received.SubagentID)
+		}
+		if _, ok := resp["decision"]; ok {
+			t.Errorf("response should not have a decision, got %v", resp)
+		}
+	})
+
+	t.Run("PreCompact hook receives trigger and instructions", func(t *testing.T) {
+		var received *PreCompactInput
+		hook := func(ctx context.Context, input *PreCompactInput, hookCtx *HookContext) (*HookOutput, error) {
+			received = input
+			return &HookOutput{SystemMessage: "compacting"}, nil
+		}
+
+		resp := runHookCallback(t, WithPreCompactHook(hook),
+			`{"hook_event_name":"PreCompact","session_id":"sess-4","trigger":"manual","custom_instructions":"keep the plan"}`)
+
+		if received == nil {
+			t.Fatal("hook was not called")
+		}
+		if received.Trigger != "manual" {
+			t.Errorf("Trigger = %q, want 'manual'", received.Trigger)
+		}
+		if received.CustomInstructions != "keep the plan" {
+			t.Errorf("CustomInstructions = %q, want 'keep the plan'", received.CustomInstructions)
+		}
+		if resp["systemMessage"] != "compacting" {
+			t.Errorf("systemMessage = %v, want 'compacting'", resp["systemMessage"])
+		}
+	})
+
+	t.Run("PostToolUse hook can block with additional context", func(t *testing.T) {
+		hook := func(ctx context.Context, input *PostToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+			return &HookOutput{Decision: HookDecisionBlock, Reason: "lint failed", AdditionalContext: "3 errors"}, nil
+		}
+
+		resp := runHookCallback(t, WithPostToolUseHook("", hook),
+			`{"hook_event_name":"PostToolUse","tool_name":"Write","tool_input":{},"tool_response":"ok"}`)
+
+		if resp["decision"] != "block" {
+			t.Errorf("decision = %v, want 'block'", resp["decision"])
+		}
+		specific, _ := resp["hookSpecificOutput"].(map[string]any)
+		if specific["additionalContext"] != "3 errors" {
+			t.Errorf("additionalContext = %v, want '3 errors'", specific["additionalContext"])
+		}
+		if _, ok := specific["permissionDecision"]; ok {
+			t.Errorf("PostToolUse should not have permissionDecision, got %v", specific)
+		}
+	})
+
+	t.Run("PreToolUse block maps to deny permission decision", func(t *testing.T) {
+		hook := func(ctx context.Context, input *PreToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+			return &HookOutput{Decision: HookDecisionBlock, Reason: "nope"}, nil
+		}
+
+		resp := runHookCallback(t, WithPreToolUseHook("", hook),
+			`{"hook_event_name":"PreToolUse","tool_name":"Bash","tool_input":{}}`)
+
+		specific, _ := resp["hookSpecificOutput"].(map[string]any)
+		if specific["permissionDecision"] != "deny" {
+			t.Errorf("permissionDecision = %v, want 'deny'", specific["permissionDecision"])
+		}
+		if specific["permissionDecisionReason"] != "nope" {
+			t.Errorf("permissionDecisionReason = %v, want 'nope'", specific["permissionDecisionReason"])
+		}
+	})
+}
+
+func TestHookCallbackContext(t *testing.T) {
+	t.Run("HookContext is populated from hook input", func(t *testing.T) {
+		var received *HookContext
+		hook := func(ctx context.Context, input *PreToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+			received = hookCtx
+			return &HookOutput{}, nil
+		}
+
+		runHookCallback(t, WithPreToolUseHook("", hook),
+			`{"hook_event_name":"PreToolUse","session_id":"sess-9","transcript_path":"/tmp/t.jsonl","cwd":"/work","permission_mode":"acceptEdits","tool_name":"Bash","tool_input":{}}`)
+
+		if received == nil {
+			t.Fatal("hook was not called")
+		}
+		want := HookContext{
+			SessionID:      "sess-9",
+			TranscriptPath: "/tmp/t.jsonl",
+			WorkingDir:     "/work",
+			PermissionMode: "acceptEdits",
+		}
+		if *received != want {
+			t.Errorf("HookContext = %+v, want %+v", *received, want)
+		}
+	})
+
+	t.Run("ctx carries the HookTimeout deadline", func(t *testing.T) {
+		var deadline time.Time
+		var hasDeadline bool
+		hook := func(ctx context.Context, input *StopInput, hookCtx *HookContext) (*HookOutput, error) {
+			deadline, hasDeadline = ctx.Deadline()
+			return &HookOutput{}, nil
+		}
+
+		start := time.Now()
+		runHookCallback(t, WithStopHook(hook, HookTimeout(5*time.Second)), `{"hook_event_name":"Stop"}`)
+
+		if !hasDeadline {
+			t.Fatal("ctx should have a deadline")
+		}
+		if remaining := deadline.Sub(start); remaining < 5*time.Second || remaining > 6*time.Second {
+			t.Errorf("deadline in %v, want about 5s", remaining)
+		}
+	})
+
+	t.Run("ctx has no deadline without HookTimeout", func(t *testing.T) {
+		hasDeadline := true
+		hook := func(ctx context.Context, input *StopInput, hookCtx *HookContext) (*HookOutput, error) {
+			_, hasDeadline = ctx.Deadline()
+			return &HookOutput{}, nil
+		}
+
+		runHookCallback(t, WithStopHook(hook), `{"hook_event_name":"Stop"}`)
+
+		if hasDeadline {
+			t.Error("ctx should not have a deadline")
+		}
+	})
+
+	for _, tc := range []struct {
+		name   string
+		cancel func(client *Client)
+	}{
+		{"ctx is cancelled when client closes", func(client *Client) { _ = client.Close() }},
+		{"ctx is cancelled when turn is interrupted", func(client *Client) { _ = client.Interrupt(context.Background()) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{})
+			hookErr := make(chan error, 1)
+			hook := func(ctx context.Context, input *PreToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+				close(started)
+				select {
+				case <-ctx.Done():
+					hookErr <- ctx.Err()
+				case <-time.After(5 * time.Second):
+					hookErr <- errors.New("ctx was not cancelled")
+				}
+				return &HookOutput{}, nil
+			}
+
+			mt := newMockTransport()
+			client := NewClient(WithTransport(mt), WithPreToolUseHook("", hook))
+			if err := client.Connect(context.Background()); err != nil {
+				t.Fatalf("Connect() error = %v", err)
+			}
+			defer client.Close()
+
+			mt.QueueMessage([]byte(`{"type":"control_request","request_id":"req-slow","request":{"subtype":"hook_callback","callback_id":"hook_0","input":{"hook_event_name":"PreToolUse","tool_name":"Bash","tool_input":{}}}}`))
+
+			<-started
+			go tc.cancel(client)
+
+			if err := <-hookErr; !errors.Is(err, context.Canceled) {
+				t.Errorf("hook ctx error = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestHookCallbackWorkers(t *testing.T) {
+	const slowRequest = `{"type":"control_request","request_id":"req-slow","request":{"subtype":"hook_callback","callback_id":"hook_0","input":{"hook_event_name":"PreToolUse","tool_name":"Bash","tool_input":{}}}}`
+
+	t.Run("slow hook does not stall message delivery", func(t *testing.T) {
+		release := make(chan struct{})
+		hook := func(ctx context.Context, input *PreToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+			<-release
+			return &HookOutput{}, nil
+		}
+
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt), WithPreToolUseHook("", hook))
+		if err := client.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Close()
+
+		mt.QueueMessage([]byte(slowRequest))
+		mt.QueueMessage([]byte(`{"type":"result","subtype":"success"}`))
+
+		select {
+		case msg := <-client.Messages():
+			if _, ok := msg.(*ResultMessage); !ok {
+				t.Errorf("expected *ResultMessage, got %T", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("message was not delivered while hook was running")
+		}
+		close(release)
+	})
+
+	t.Run("hook can read client messages", func(t *testing.T) {
+		var client *Client
+		got := make(chan Message, 1)
+		hook := func(ctx context.Context, input *PreToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+			got <- <-client.Messages()
+			return &HookOutput{}, nil
+		}
+
+		mt := newMockTransport()
+		client = NewClient(WithTransport(mt), WithPreToolUseHook("", hook))
+		if err := client.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Close()
+
+		mt.QueueMessage([]byte(slowRequest))
+		mt.QueueMessage([]byte(`{"type":"result","subtype":"success"}`))
+
+		select {
+		case msg := <-got:
+			if _, ok := msg.(*ResultMessage); !ok {
+				t.Errorf("expected *ResultMessage, got %T", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("hook deadlocked reading messages")
+		}
+	})
+
+	t.Run("panicking hook is reported as control error", func(t *testing.T) {
+		hook := func(ctx context.Context, input *PreToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+			panic("boom")
+		}
+
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt), WithPreToolUseHook("", hook))
+		if err := client.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Close()
+
+		mt.QueueMessage([]byte(slowRequest))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		var found bool
+		for _, msg := range mt.sentMessages {
+			msgStr := string(msg)
+			if strings.Contains(msgStr, "req-slow") {
+				found = true
+				if !strings.Contains(msgStr, `"subtype":"error"`) || !strings.Contains(msgStr, "boom") {
+					t.Errorf("response should be a control error mentioning the panic, got: %s", msgStr)
+				}
+			}
+		}
+		if !found {
+			t.Error("control_response not found")
+		}
+	})
+
+	t.Run("concurrency is bounded by WithCallbackConcurrency", func(t *testing.T) {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		hook := func(ctx context.Context, input *PreToolUseInput, hookCtx *HookContext) (*HookOutput, error) {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return &HookOutput{}, nil
+		}
+
+		mt := newMockTransport()
+		client := NewClient(
+			WithTransport(mt),
+			WithPreToolUseHook("", hook),
+			WithCallbackConcurrency(2),
+		)
+		if err := client.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer client.Close()
+
+		for range 6 {
+			mt.QueueMessage([]byte(slowRequest))
+		}
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		if maxRunning != 2 {
+			t.Errorf("max concurrent hooks = %d, want 2", maxRunning)
+		}
+	})
+}
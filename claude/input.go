@@ -0,0 +1,112 @@
+package claude
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Media types accepted for image input.
+var imageMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+const (
+	mediaTypePDF       = "application/pdf"
+	mediaTypeTextPlain = "text/plain"
+)
+
+// InputBlock is a piece of prompt content sent with Client.QueryContent.
+// Create one with TextInput, ImageInput, DocumentInput or FileInput.
+type InputBlock struct {
+	block *ContentBlock
+	path  string
+}
+
+// TextInput creates a text input block.
+func TextInput(text string) InputBlock {
+	return InputBlock{block: NewTextBlock(text)}
+}
+
+// ImageInput creates an image input block from raw image bytes.
+// If mediaType is empty it is detected from the data.
+func ImageInput(mediaType string, data []byte) InputBlock {
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+	return InputBlock{block: NewImageBlock(&ContentSource{
+		Type:      "base64",
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	})}
+}
+
+// DocumentInput creates a document input block, such as a PDF, from raw
+// bytes. Plain text documents (mediaType "text/plain") are sent as text;
+// everything else is base64-encoded. The title is optional.
+func DocumentInput(mediaType string, data []byte, title string) InputBlock {
+	source := &ContentSource{Type: "base64", MediaType: mediaType}
+	if mediaType == mediaTypeTextPlain {
+		source.Type = "text"
+		source.Data = string(data)
+	} else {
+		source.Data = base64.StdEncoding.EncodeToString(data)
+	}
+	return InputBlock{block: NewDocumentBlock(source, title)}
+}
+
+// FileInput creates an input block from a local file. The file is read
+// when the query is sent. Its MIME type is detected from the extension,
+// falling back to the content: images become image blocks, PDFs and text
+// files become document blocks titled with the file name. Other file
+// types make QueryContent return an error.
+func FileInput(path string) InputBlock {
+	return InputBlock{path: path}
+}
+
+// resolve returns the content block, reading the file for FileInput.
+func (b InputBlock) resolve() (*ContentBlock, error) {
+	if b.path == "" {
+		if b.block == nil {
+			return nil, errors.New("claude: empty input block")
+		}
+		return b.block, nil
+	}
+
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return nil, fmt.Errorf("claude: reading input file: %w", err)
+	}
+
+	mediaType := detectMediaType(b.path, data)
+	switch {
+	case imageMediaTypes[mediaType]:
+		return ImageInput(mediaType, data).block, nil
+	case mediaType == mediaTypePDF:
+		return DocumentInput(mediaType, data, filepath.Base(b.path)).block, nil
+	case strings.HasPrefix(mediaType, "text/"):
+		return DocumentInput(mediaTypeTextPlain, data, filepath.Base(b.path)).block, nil
+	default:
+		return nil, fmt.Errorf("claude: unsupported input file type %q for %s", mediaType, b.path)
+	}
+}
+
+// detectMediaType returns the MIME type for a file, without parameters.
+func detectMediaType(path string, data []byte) string {
+	mediaType := mime.TypeByExtension(filepath.Ext(path))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		return parsed
+	}
+	return mediaType
+}
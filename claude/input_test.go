@@ -0,0 +1,209 @@
+package claude
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngHeader is the signature at the start of every PNG file.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestInputBlocks(t *testing.T) {
+	t.Run("TextInput creates text block", func(t *testing.T) {
+		block, err := TextInput("hello").resolve()
+
+		if err != nil {
+			t.Fatalf("resolve() error = %v", err)
+		}
+		if !block.IsText() || block.Text != "hello" {
+			t.Errorf("block = %+v, want text 'hello'", block)
+		}
+	})
+
+	t.Run("ImageInput base64-encodes data and detects media type", func(t *testing.T) {
+		block, _ := ImageInput("", pngHeader).resolve()
+
+		if !block.IsImage() {
+			t.Fatalf("Kind = %v, want BlockImage", block.Kind)
+		}
+		if block.Source.Type != "base64" || block.Source.MediaType != "image/png" {
+			t.Errorf("Source = %+v, want base64 image/png", block.Source)
+		}
+		if block.Source.Data != base64.StdEncoding.EncodeToString(pngHeader) {
+			t.Errorf("Data = %q, want base64 of input", block.Source.Data)
+		}
+	})
+
+	t.Run("DocumentInput sends plain text as text source", func(t *testing.T) {
+		block, _ := DocumentInput("text/plain", []byte("notes"), "notes.txt").resolve()
+
+		if !block.IsDocument() || block.Title != "notes.txt" {
+			t.Fatalf("block = %+v, want document titled notes.txt", block)
+		}
+		if block.Source.Type != "text" || block.Source.Data != "notes" {
+			t.Errorf("Source = %+v, want text source 'notes'", block.Source)
+		}
+	})
+
+	t.Run("empty InputBlock is an error", func(t *testing.T) {
+		if _, err := (InputBlock{}).resolve(); err == nil {
+			t.Error("resolve() error = nil, want error")
+		}
+	})
+}
+
+func TestFileInput(t *testing.T) {
+	writeFile := func(t *testing.T, name string, data []byte) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		return path
+	}
+
+	t.Run("image file becomes image block", func(t *testing.T) {
+		block, err := FileInput(writeFile(t, "shot.png", pngHeader)).resolve()
+
+		if err != nil {
+			t.Fatalf("resolve() error = %v", err)
+		}
+		if !block.IsImage() || block.Source.MediaType != "image/png" {
+			t.Errorf("block = %+v, want png image", block)
+		}
+	})
+
+	t.Run("detects media type from content without extension", func(t *testing.T) {
+		block, err := FileInput(writeFile(t, "screenshot", pngHeader)).resolve()
+
+		if err != nil {
+			t.Fatalf("resolve() error = %v", err)
+		}
+		if !block.IsImage() || block.Source.MediaType != "image/png" {
+			t.Errorf("block = %+v, want png image", block)
+		}
+	})
+
+	t.Run("PDF becomes base64 document titled with file name", func(t *testing.T) {
+		block, err := FileInput(writeFile(t, "design.pdf", []byte("%PDF-1.7\n"))).resolve()
+
+		if err != nil {
+			t.Fatalf("resolve() error = %v", err)
+		}
+		if !block.IsDocument() || block.Title != "design.pdf" {
+			t.Fatalf("block = %+v, want document titled design.pdf", block)
+		}
+		if block.Source.Type != "base64" || block.Source.MediaType != "application/pdf" {
+			t.Errorf("Source = %+v, want base64 application/pdf", block.Source)
+		}
+	})
+
+	t.Run("text file becomes text document", func(t *testing.T) {
+		block, err := FileInput(writeFile(t, "README.md", []byte("# Title"))).resolve()
+
+		if err != nil {
+			t.Fatalf("resolve() error = %v", err)
+		}
+		if !block.IsDocument() || block.Source.Type != "text" || block.Source.Data != "# Title" {
+			t.Errorf("block = %+v, want text document", block)
+		}
+	})
+
+	t.Run("unsupported file type is an error", func(t *testing.T) {
+		_, err := FileInput(writeFile(t, "archive.zip", []byte("PK\x03\x04"))).resolve()
+
+		if err == nil || !strings.Contains(err.Error(), "unsupported input file type") {
+			t.Errorf("resolve() error = %v, want unsupported file type", err)
+		}
+	})
+
+	t.Run("missing file is an error", func(t *testing.T) {
+		_, err := FileInput(filepath.Join(t.TempDir(), "missing.png")).resolve()
+
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("resolve() error = %v, want os.ErrNotExist", err)
+		}
+	})
+}
+
+func TestClientQueryContent(t *testing.T) {
+	t.Run("sends content blocks in stream-json format", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		err := client.QueryContent(context.Background(),
+			TextInput("Review this"),
+			ImageInput("image/png", pngHeader),
+			DocumentInput("application/pdf", []byte("%PDF"), "spec.pdf"),
+		)
+
+		if err != nil {
+			t.Fatalf("QueryContent() error = %v", err)
+		}
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+
+		var sent struct {
+			Type    string `json:"type"`
+			Message struct {
+				Role    string           `json:"role"`
+				Content []map[string]any `json:"content"`
+			} `json:"message"`
+		}
+		if err := json.Unmarshal(mt.sentMessages[0], &sent); err != nil {
+			t.Fatalf("failed to unmarshal sent message: %v", err)
+		}
+		if sent.Type != "user" || sent.Message.Role != "user" {
+			t.Errorf("type = %q, role = %q, want user message", sent.Type, sent.Message.Role)
+		}
+		content := sent.Message.Content
+		if len(content) != 3 {
+			t.Fatalf("content length = %d, want 3", len(content))
+		}
+		if content[0]["type"] != "text" || content[0]["text"] != "Review this" {
+			t.Errorf("content[0] = %v, want text block", content[0])
+		}
+		source, _ := content[1]["source"].(map[string]any)
+		if content[1]["type"] != "image" || source["media_type"] != "image/png" || source["type"] != "base64" {
+			t.Errorf("content[1] = %v, want base64 png image", content[1])
+		}
+		if content[2]["type"] != "document" || content[2]["title"] != "spec.pdf" {
+			t.Errorf("content[2] = %v, want document titled spec.pdf", content[2])
+		}
+	})
+
+	t.Run("returns file errors without sending", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		err := client.QueryContent(context.Background(), TextInput("hi"), FileInput("/does/not/exist.png"))
+
+		if err == nil {
+			t.Fatal("QueryContent() error = nil, want error")
+		}
+		if len(mt.sentMessages) != 0 {
+			t.Errorf("sentMessages length = %d, want 0", len(mt.sentMessages))
+		}
+	})
+
+	t.Run("fails when not connected", func(t *testing.T) {
+		client := NewClient()
+
+		err := client.QueryContent(context.Background(), TextInput("hi"))
+
+		if !errors.Is(err, ErrNotConnected) {
+			t.Errorf("QueryContent() error = %v, want %v", err, ErrNotConnected)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package claude
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// userMessageJSON is the stream-json format of a user message.
+type userMessageJSON struct {
+	Type            string         `json:"type"`
+	Message         apiMessageJSON `json:"message"`
+	UUID            string         `json:"uuid,omitempty"`
+	ParentToolUseID string         `json:"parent_tool_use_id,omitempty"`
+}
+
+// assistantMessageJSON is the stream-json format of an assistant message.
+type assistantMessageJSON struct {
+	Type            string         `json:"type"`
+	Message         apiMessageJSON `json:"message"`
+	ParentToolUseID string         `json:"parent_tool_use_id,omitempty"`
+	Error           string         `json:"error,omitempty"`
+}
+
+// apiMessageJSON is an Anthropic API message object.
+type apiMessageJSON struct {
+	ID         string          `json:"id,omitempty"`
+	Role       string          `json:"role"`
+	Model      string          `json:"model,omitempty"`
+	Content    []*ContentBlock `json:"content"`
+	StopReason string          `json:"stop_reason,omitempty"`
+	Usage      *Usage          `json:"usage,omitempty"`
+}
+
+// MarshalMessage encodes msg as a line of the CLI's stream-json output.
+// Its "type" field, and "subtype" for system messages, identify the
+// concrete message type, so UnmarshalMessage can decode it back.
+//
+// Only modeled fields are encoded, not Raw, except that an UnknownMessage
+// is encoded as its Raw JSON. A StreamEvent is encoded from its Event, and
+// its typed fields are decoded from it again by UnmarshalMessage.
+//
+// Example:
+//
+//	for _, msg := range conversation {
+//	    line, err := claude.MarshalMessage(msg)
+//	    if err != nil {
+//	        return err
+//	    }
+//	    w.Write(append(line, '\n'))
+//	}
+func MarshalMessage(msg Message) ([]byte, error) {
+	switch m := msg.(type) {
+	case *UserMessage:
+		return json.Marshal(userMessageJSON{
+			Type:            "user",
+			Message:         apiMessageJSON{Role: "user", Content: m.Content},
+			UUID:            m.UUID,
+			ParentToolUseID: m.ParentToolUseID,
+		})
+	case *AssistantMessage:
+		return json.Marshal(assistantMessageJSON{
+			Type: "assistant",
+			Message: apiMessageJSON{
+				ID:         m.ID,
+				Role:       "assistant",
+				Model:      m.Model,
+				Content:    m.Content,
+				StopReason: m.StopReason,
+				Usage:      &m.Usage,
+			},
+			ParentToolUseID: m.ParentToolUseID,
+			Error:           m.Error,
+		})
+	case *SystemMessage:
+		out := *m
+		if out.Data == nil {
+			out.Data = map[string]any{}
+		}
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*SystemMessage
+		}{"system", &out})
+	case *SystemInitMessage:
+		return marshalWithExtra(struct {
+			Type    string `json:"type"`
+			Subtype string `json:"subtype"`
+			*SystemInitMessage
+		}{"system", SystemSubtypeInit, m}, m.Extra)
+	case *SystemCompactBoundaryMessage:
+		return marshalWithExtra(struct {
+			Type    string `json:"type"`
+			Subtype string `json:"subtype"`
+			*SystemCompactBoundaryMessage
+		}{"system", SystemSubtypeCompactBoundary, m}, m.Extra)
+	case *ResultMessage:
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*ResultMessage
+		}{"result", m})
+	case *StreamEvent:
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			*StreamEvent
+		}{"stream_event", m})
+	case *UnknownMessage:
+		if len(m.Raw) > 0 {
+			return m.Raw, nil
+		}
+		return json.Marshal(m)
+	default:
+		return nil, fmt.Errorf("claude: cannot marshal message of type %T", msg)
+	}
+}
+
+// marshalWithExtra marshals v, a struct, and adds the extra fields that
+// v does not already have.
+func marshalWithExtra(v any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalMessage decodes a message encoded by MarshalMessage, or a line
+// of the CLI's stream-json output, into its concrete type. A message of
+// unknown type is returned as an *UnknownMessage. Raw is set to a copy of
+// data.
+//
+// A decoded error ResultMessage does not know the API error that caused
+// it, so its Err wraps ErrExecution rather than returning an *APIError;
+// the assistant message before it has the details.
+func UnmarshalMessage(data []byte) (Message, error) {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, &JSONDecodeError{Line: string(data), OriginalError: err}
+	}
+	if envelope.Type == MessageTypeControlRequest || envelope.Type == MessageTypeControlResponse {
+		return nil, &JSONDecodeError{
+			Line:          string(data),
+			OriginalError: fmt.Errorf("%s is not a conversation message", envelope.Type),
+		}
+	}
+
+	// A standalone client decodes the message exactly as a session would,
+	// without any session state to update.
+	c := &Client{cfg: &config{}}
+	return c.parseMessage(bytes.Clone(data))
+}
@@ -0,0 +1,188 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalMessage(t *testing.T) {
+	t.Run("round trips every message type", func(t *testing.T) {
+		messages := []Message{
+			&UserMessage{
+				Content: []*ContentBlock{NewToolResultBlock("tool-1", "ok", false)},
+				UUID:    "u1",
+			},
+			&AssistantMessage{
+				Content: []*ContentBlock{
+					NewThinkingBlock("hmm", "sig"),
+					NewTextBlock("Reading"),
+					NewToolUseBlock("tool-1", "Read", map[string]any{"file_path": "/a"}),
+				},
+				Model:           "claude-sonnet-4-5",
+				ID:              "msg_1",
+				StopReason:      "tool_use",
+				Usage:           Usage{InputTokens: 10, OutputTokens: 5, ServiceTier: "standard"},
+				ParentToolUseID: "task-1",
+			},
+			&SystemMessage{Subtype: "status", Data: map[string]any{"status": "compacting"}},
+			&SystemInitMessage{
+				SessionID:      "s",
+				Model:          "claude-sonnet-4-5",
+				Tools:          []string{"Read"},
+				MCPServers:     []MCPServerStatus{},
+				SlashCommands:  []string{},
+				PermissionMode: PermissionDefault,
+				Extra:          map[string]json.RawMessage{"agents": json.RawMessage(`["a"]`)},
+			},
+			&SystemCompactBoundaryMessage{
+				SessionID: "s",
+				Metadata:  CompactMetadata{Trigger: "auto", PreTokens: 1000},
+			},
+			&ResultMessage{
+				Subtype:      ResultSubtypeSuccess,
+				DurationMS:   100,
+				NumTurns:     2,
+				SessionID:    "s",
+				TotalCostUSD: 0.01,
+				Result:       "done",
+				ModelUsage:   map[string]ModelUsage{"m": {InputTokens: 1, CostUSD: 0.01}},
+				PermissionDenials: []PermissionDenial{
+					{ToolName: "Bash", ToolUseID: "tool-2", ToolInput: map[string]any{"command": "rm"}},
+				},
+			},
+			&UnknownMessage{Type: "future", Raw: json.RawMessage(`{"type":"future","x":1}`)},
+		}
+
+		for _, msg := range messages {
+			data, err := MarshalMessage(msg)
+			if err != nil {
+				t.Fatalf("MarshalMessage(%T) error = %v", msg, err)
+			}
+			got, err := UnmarshalMessage(data)
+			if err != nil {
+				t.Fatalf("UnmarshalMessage(%s) error = %v", data, err)
+			}
+
+			clearRaw(t, got, data)
+			clearRaw(t, msg, nil)
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("round trip of %T:\n got  %#v\n want %#v", msg, got, msg)
+			}
+		}
+	})
+
+	t.Run("round trips stream events", func(t *testing.T) {
+		event := &StreamEvent{
+			UUID:      "e1",
+			SessionID: "s",
+			Event:     json.RawMessage(`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hi"}}`),
+		}
+
+		data, err := MarshalMessage(event)
+		if err != nil {
+			t.Fatalf("MarshalMessage() error = %v", err)
+		}
+		got, err := UnmarshalMessage(data)
+		if err != nil {
+			t.Fatalf("UnmarshalMessage() error = %v", err)
+		}
+
+		decoded, ok := got.(*StreamEvent)
+		if !ok {
+			t.Fatalf("UnmarshalMessage() = %T, want *StreamEvent", got)
+		}
+		if decoded.Type != StreamContentBlockDelta || decoded.Index != 1 || decoded.Delta.Text != "Hi" {
+			t.Errorf("decoded = %+v, want typed text delta at index 1", decoded)
+		}
+		if string(decoded.Event) != string(event.Event) {
+			t.Errorf("Event = %v, want %v", decoded.Event, event.Event)
+		}
+	})
+
+	t.Run("uses the stream-json wire format", func(t *testing.T) {
+		msg := &AssistantMessage{Content: []*ContentBlock{NewTextBlock("Hi")}, Model: "m"}
+
+		data, err := MarshalMessage(msg)
+		if err != nil {
+			t.Fatalf("MarshalMessage() error = %v", err)
+		}
+
+		want := `{"type":"assistant","message":{"role":"assistant","model":"m","content":[{"type":"text","text":"Hi"}],` +
+			`"usage":{"input_tokens":0,"output_tokens":0,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,` +
+			`"server_tool_use":{"web_search_requests":0,"web_fetch_requests":0}}}}`
+		if string(data) != want {
+			t.Errorf("MarshalMessage() =\n %s\nwant\n %s", data, want)
+		}
+	})
+
+	t.Run("returns error for nil message", func(t *testing.T) {
+		if _, err := MarshalMessage(nil); err == nil {
+			t.Error("MarshalMessage(nil) error = nil, want error")
+		}
+	})
+}
+
+func TestUnmarshalMessage(t *testing.T) {
+	t.Run("decodes CLI output", func(t *testing.T) {
+		line := []byte(`{"type":"assistant","message":{"content":[{"type":"text","text":"hi"}],"model":"m"},"session_id":"s"}`)
+
+		msg, err := UnmarshalMessage(line)
+		if err != nil {
+			t.Fatalf("UnmarshalMessage() error = %v", err)
+		}
+
+		assistant, ok := msg.(*AssistantMessage)
+		if !ok {
+			t.Fatalf("UnmarshalMessage() = %T, want *AssistantMessage", msg)
+		}
+		if assistant.Content[0].Text != "hi" || string(assistant.Raw) != string(line) {
+			t.Errorf("message = %+v, want text %q and Raw set", assistant, "hi")
+		}
+
+		line[0] = ' '
+		if assistant.Raw[0] != '{' {
+			t.Error("Raw shares memory with the input")
+		}
+	})
+
+	t.Run("returns unknown types as UnknownMessage", func(t *testing.T) {
+		msg, err := UnmarshalMessage([]byte(`{"type":"future"}`))
+
+		if unknown, ok := msg.(*UnknownMessage); err != nil || !ok || unknown.Type != "future" {
+			t.Errorf("UnmarshalMessage() = %#v, %v, want *UnknownMessage", msg, err)
+		}
+	})
+
+	t.Run("returns JSONDecodeError for malformed JSON", func(t *testing.T) {
+		_, err := UnmarshalMessage([]byte(`{"type":`))
+
+		var decodeErr *JSONDecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("UnmarshalMessage() error = %v, want *JSONDecodeError", err)
+		}
+	})
+
+	t.Run("rejects control messages", func(t *testing.T) {
+		_, err := UnmarshalMessage([]byte(`{"type":"control_request","request_id":"r1","request":{}}`))
+
+		var decodeErr *JSONDecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("UnmarshalMessage() error = %v, want *JSONDecodeError", err)
+		}
+	})
+}
+
+// clearRaw checks that msg's Raw field equals want, then clears it.
+func clearRaw(t *testing.T, msg Message, want []byte) {
+	t.Helper()
+	if _, ok := msg.(*UnknownMessage); ok {
+		return
+	}
+	raw := reflect.ValueOf(msg).Elem().FieldByName("Raw")
+	if string(raw.Bytes()) != string(want) {
+		t.Errorf("%T Raw = %s, want %s", msg, raw.Bytes(), want)
+	}
+	raw.SetBytes(nil)
+}
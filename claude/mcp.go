@@ -0,0 +1,226 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+)
+
+// mcpProtocolVersion is the MCP protocol version reported by SDK servers.
+const mcpProtocolVersion = "2024-11-05"
+
+// JSON-RPC error codes used in MCP responses.
+const (
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+)
+
+// MCPToolHandler is the Go function backing an in-process MCP tool.
+// The args map holds the tool arguments as sent by Claude.
+// Returning an error reports a failed tool call to Claude.
+type MCPToolHandler func(ctx context.Context, args map[string]any) (*MCPToolResult, error)
+
+// MCPTool describes a tool exposed by an in-process MCP server.
+type MCPTool struct {
+	// Name is the tool name Claude uses to call it.
+	Name string
+
+	// Description explains what the tool does.
+	Description string
+
+	// InputSchema is the JSON schema for the tool arguments.
+	InputSchema map[string]any
+
+	// Handler is invoked when Claude calls the tool.
+	Handler MCPToolHandler
+}
+
+// MCPToolResult is the result of an in-process MCP tool call.
+type MCPToolResult struct {
+	// Content holds the result items returned to Claude.
+	Content []MCPContent `json:"content"`
+
+	// IsError indicates the tool call failed.
+	IsError bool `json:"isError,omitempty"`
+}
+
+// MCPContent is a single content item in an MCP tool result.
+type MCPContent struct {
+	// Type is the content type ("text" or "image").
+	Type string `json:"type"`
+
+	// Text is the text content (Type == "text").
+	Text string `json:"text,omitempty"`
+
+	// Data is base64-encoded data (Type == "image").
+	Data string `json:"data,omitempty"`
+
+	// MimeType is the MIME type of Data (Type == "image").
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// SDKMCPServer is an MCP server that runs inside the Go process.
+// Claude calls its tools over the control protocol, so no separate
+// server process or config file is needed.
+//
+// Example:
+//
+//	add := claude.NewMCPTool("add", "Add two numbers",
+//	    map[string]any{
+//	        "type": "object",
+//	        "properties": map[string]any{
+//	            "a": map[string]any{"type": "number"},
+//	            "b": map[string]any{"type": "number"},
+//	        },
+//	    },
+//	    func(ctx context.Context, args map[string]any) (*claude.MCPToolResult, error) {
+//	        a, _ := args["a"].(float64)
+//	        b, _ := args["b"].(float64)
+//	        return claude.NewMCPTextResult(fmt.Sprint(a + b)), nil
+//	    },
+//	)
+//
+//	client := claude.NewClient(
+//	    claude.WithMCPServers(claude.NewSDKMCPServer("calc", "1.0.0", add)),
+//	    claude.WithAllowedTools("mcp__calc__add"),
+//	)
+type SDKMCPServer struct {
+	name    string
+	version string
+	tools   []*MCPTool
+	byName  map[string]*MCPTool
+}
+
+// NewSDKMCPServer creates an in-process MCP server with the given tools.
+func NewSDKMCPServer(name, version string, tools ...*MCPTool) *SDKMCPServer {
+	s := &SDKMCPServer{
+		name:    name,
+		version: version,
+		tools:   tools,
+		byName:  make(map[string]*MCPTool, len(tools)),
+	}
+	for _, tool := range tools {
+		s.byName[tool.Name] = tool
+	}
+	return s
+}
+
+// NewMCPTool creates a tool for use with NewSDKMCPServer.
+func NewMCPTool(name, description string, inputSchema map[string]any, handler MCPToolHandler) *MCPTool {
+	return &MCPTool{
+		Name:        name,
+		Description: description,
+		InputSchema: inputSchema,
+		Handler:     handler,
+	}
+}
+
+// NewMCPTextResult creates a tool result with a single text item.
+func NewMCPTextResult(text string) *MCPToolResult {
+	return &MCPToolResult{
+		Content: []MCPContent{{Type: "text", Text: text}},
+	}
+}
+
+// Name returns the server name.
+func (s *SDKMCPServer) Name() string {
+	return s.name
+}
+
+// Version returns the server version.
+func (s *SDKMCPServer) Version() string {
+	return s.version
+}
+
+// handleMessage answers a JSON-RPC request from the CLI.
+func (s *SDKMCPServer) handleMessage(ctx context.Context, msg map[string]any) map[string]any {
+	id := msg["id"]
+	method := getString(msg, "method")
+	params := getMap(msg, "params")
+
+	switch method {
+	case "initialize":
+		return jsonRPCResult(id, map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities": map[string]any{
+				"tools": map[string]any{},
+			},
+			"serverInfo": map[string]any{
+				"name":    s.name,
+				"version": s.version,
+			},
+		})
+
+	case "notifications/initialized":
+		return jsonRPCResult(id, map[string]any{})
+
+	case "tools/list":
+		tools := make([]map[string]any, 0, len(s.tools))
+		for _, tool := range s.tools {
+			schema := tool.InputSchema
+			if schema == nil {
+				schema = map[string]any{"type": "object"}
+			}
+			tools = append(tools, map[string]any{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"inputSchema": schema,
+			})
+		}
+		return jsonRPCResult(id, map[string]any{"tools": tools})
+
+	case "tools/call":
+		return s.callTool(ctx, id, params)
+
+	default:
+		return jsonRPCError(id, jsonRPCMethodNotFound, fmt.Sprintf("Method '%s' not found", method))
+	}
+}
+
+// callTool runs a tool handler and converts its outcome to a JSON-RPC response.
+func (s *SDKMCPServer) callTool(ctx context.Context, id any, params map[string]any) map[string]any {
+	name := getString(params, "name")
+	tool, ok := s.byName[name]
+	if !ok || tool.Handler == nil {
+		return jsonRPCError(id, jsonRPCInvalidParams, fmt.Sprintf("Tool '%s' not found", name))
+	}
+
+	args := getMap(params, "arguments")
+	if args == nil {
+		args = map[string]any{}
+	}
+
+	result, err := tool.Handler(ctx, args)
+	if err != nil {
+		result = &MCPToolResult{
+			Content: []MCPContent{{Type: "text", Text: err.Error()}},
+			IsError: true,
+		}
+	}
+	if result == nil {
+		result = &MCPToolResult{}
+	}
+	if result.Content == nil {
+		result.Content = []MCPContent{}
+	}
+
+	return jsonRPCResult(id, result)
+}
+
+func jsonRPCResult(id, result any) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"result":  result,
+	}
+}
+
+func jsonRPCError(id any, code int, message string) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error": map[string]any{
+			"code":    code,
+			"message": message,
+		},
+	}
+}
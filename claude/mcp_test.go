@@ -0,0 +1,219 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestMCPServer() *SDKMCPServer {
+	echo := NewMCPTool("echo", "Echo the input",
+		map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"text": map[string]any{"type": "string"},
+			},
+		},
+		func(ctx context.Context, args map[string]any) (*MCPToolResult, error) {
+			text, _ := args["text"].(string)
+			return NewMCPTextResult(text), nil
+		},
+	)
+	fail := NewMCPTool("fail", "Always fails", nil,
+		func(ctx context.Context, args map[string]any) (*MCPToolResult, error) {
+			return nil, errors.New("tool exploded")
+		},
+	)
+	return NewSDKMCPServer("test", "1.2.3", echo, fail)
+}
+
+func TestNewSDKMCPServer(t *testing.T) {
+	t.Run("stores name and version", func(t *testing.T) {
+		s := newTestMCPServer()
+
+		if s.Name() != "test" {
+			t.Errorf("Name() = %q, want 'test'", s.Name())
+		}
+		if s.Version() != "1.2.3" {
+			t.Errorf("Version() = %q, want '1.2.3'", s.Version())
+		}
+	})
+}
+
+func TestSDKMCPServerHandleMessage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("initialize reports server info", func(t *testing.T) {
+		s := newTestMCPServer()
+
+		resp := s.handleMessage(ctx, map[string]any{"jsonrpc": "2.0", "id": 1.0, "method": "initialize"})
+
+		result, _ := resp["result"].(map[string]any)
+		info, _ := result["serverInfo"].(map[string]any)
+		if info["name"] != "test" || info["version"] != "1.2.3" {
+			t.Errorf("serverInfo = %v, want test/1.2.3", info)
+		}
+		if result["protocolVersion"] != mcpProtocolVersion {
+			t.Errorf("protocolVersion = %v, want %q", result["protocolVersion"], mcpProtocolVersion)
+		}
+		if resp["id"] != 1.0 {
+			t.Errorf("id = %v, want 1", resp["id"])
+		}
+	})
+
+	t.Run("tools/list returns all tools", func(t *testing.T) {
+		s := newTestMCPServer()
+
+		resp := s.handleMessage(ctx, map[string]any{"jsonrpc": "2.0", "id": 2.0, "method": "tools/list"})
+
+		result, _ := resp["result"].(map[string]any)
+		tools, _ := result["tools"].([]map[string]any)
+		if len(tools) != 2 {
+			t.Fatalf("tools length = %d, want 2", len(tools))
+		}
+		if tools[0]["name"] != "echo" {
+			t.Errorf("tools[0].name = %v, want 'echo'", tools[0]["name"])
+		}
+		schema, _ := tools[1]["inputSchema"].(map[string]any)
+		if schema["type"] != "object" {
+			t.Errorf("default inputSchema = %v, want object schema", schema)
+		}
+	})
+
+	t.Run("tools/call invokes handler", func(t *testing.T) {
+		s := newTestMCPServer()
+
+		resp := s.handleMessage(ctx, map[string]any{
+			"jsonrpc": "2.0",
+			"id":      3.0,
+			"method":  "tools/call",
+			"params": map[string]any{
+				"name":      "echo",
+				"arguments": map[string]any{"text": "hello"},
+			},
+		})
+
+		result, ok := resp["result"].(*MCPToolResult)
+		if !ok {
+			t.Fatalf("result = %T, want *MCPToolResult", resp["result"])
+		}
+		if len(result.Content) != 1 || result.Content[0].Text != "hello" {
+			t.Errorf("Content = %+v, want text 'hello'", result.Content)
+		}
+	})
+
+	t.Run("tools/call reports handler errors as tool errors", func(t *testing.T) {
+		s := newTestMCPServer()
+
+		resp := s.handleMessage(ctx, map[string]any{
+			"jsonrpc": "2.0",
+			"id":      4.0,
+			"method":  "tools/call",
+			"params":  map[string]any{"name": "fail"},
+		})
+
+		result, _ := resp["result"].(*MCPToolResult)
+		if result == nil || !result.IsError {
+			t.Fatalf("result = %+v, want IsError", result)
+		}
+		if result.Content[0].Text != "tool exploded" {
+			t.Errorf("Content[0].Text = %q, want 'tool exploded'", result.Content[0].Text)
+		}
+	})
+
+	t.Run("tools/call with unknown tool returns JSON-RPC error", func(t *testing.T) {
+		s := newTestMCPServer()
+
+		resp := s.handleMessage(ctx, map[string]any{
+			"jsonrpc": "2.0",
+			"id":      5.0,
+			"method":  "tools/call",
+			"params":  map[string]any{"name": "missing"},
+		})
+
+		rpcErr, _ := resp["error"].(map[string]any)
+		if rpcErr["code"] != jsonRPCInvalidParams {
+			t.Errorf("error code = %v, want %d", rpcErr["code"], jsonRPCInvalidParams)
+		}
+	})
+
+	t.Run("unknown method returns method not found", func(t *testing.T) {
+		s := newTestMCPServer()
+
+		resp := s.handleMessage(ctx, map[string]any{"jsonrpc": "2.0", "id": 6.0, "method": "resources/list"})
+
+		rpcErr, _ := resp["error"].(map[string]any)
+		if rpcErr["code"] != jsonRPCMethodNotFound {
+			t.Errorf("error code = %v, want %d", rpcErr["code"], jsonRPCMethodNotFound)
+		}
+	})
+}
+
+func TestClientMCPMessage(t *testing.T) {
+	t.Run("answers mcp_message through SDK server", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt), WithMCPServers(newTestMCPServer()))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		controlReq := `{"type":"control_request","request_id":"req-mcp","request":{"subtype":"mcp_message","server_name":"test","message":{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}}}`
+		mt.QueueMessage([]byte(controlReq))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+
+		var resp struct {
+			Response struct {
+				Subtype   string `json:"subtype"`
+				RequestID string `json:"request_id"`
+				Response  struct {
+					MCPResponse struct {
+						ID     float64       `json:"id"`
+						Result MCPToolResult `json:"result"`
+					} `json:"mcp_response"`
+				} `json:"response"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(mt.sentMessages[0], &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.Response.RequestID != "req-mcp" {
+			t.Errorf("request_id = %q, want 'req-mcp'", resp.Response.RequestID)
+		}
+		mcpResp := resp.Response.Response.MCPResponse
+		if mcpResp.ID != 7 {
+			t.Errorf("mcp id = %v, want 7", mcpResp.ID)
+		}
+		if len(mcpResp.Result.Content) != 1 || mcpResp.Result.Content[0].Text != "hi" {
+			t.Errorf("mcp result = %+v, want text 'hi'", mcpResp.Result)
+		}
+	})
+
+	t.Run("returns JSON-RPC error for unknown server", func(t *testing.T) {
+		mt := newMockTransport()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		controlReq := `{"type":"control_request","request_id":"req-mcp","request":{"subtype":"mcp_message","server_name":"nope","message":{"jsonrpc":"2.0","id":1,"method":"tools/list"}}}`
+		mt.QueueMessage([]byte(controlReq))
+		mt.CloseMessages()
+
+		for range client.Messages() {
+		}
+
+		if len(mt.sentMessages) != 1 {
+			t.Fatalf("sentMessages length = %d, want 1", len(mt.sentMessages))
+		}
+		if !strings.Contains(string(mt.sentMessages[0]), "Server 'nope' not found") {
+			t.Errorf("response should report unknown server, got: %s", mt.sentMessages[0])
+		}
+	})
+}
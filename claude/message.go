@@ -1,5 +1,11 @@
 package claude
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // Message is the interface for all message types in a conversation.
 //
 // Design rationale: Using an interface with separate concrete types rather than
@@ -14,7 +20,7 @@ package claude
 //
 //	switch m := msg.(type) {
 //	case *UserMessage:
-//	    fmt.Println(m.Content)
+//	    fmt.Println(m.Text())
 //	case *AssistantMessage:
 //	    for _, block := range m.Content { ... }
 //	case *ResultMessage:
@@ -26,20 +32,37 @@ type Message interface {
 	messageMarker()
 }
 
-// UserMessage represents a message from the user.
+// UserMessage represents a message from the user. Besides prompts, the
+// CLI sends tool results back to Claude as user messages.
 type UserMessage struct {
-	// Content is the user's message text.
-	Content string `json:"content"`
+	// Content contains the message blocks. A plain text prompt is a
+	// single text block; tool results are tool_result blocks.
+	Content []*ContentBlock `json:"content"`
 
 	// UUID is the unique identifier for this message (optional).
 	UUID string `json:"uuid,omitempty"`
 
 	// ParentToolUseID links this message to a tool use (optional).
 	ParentToolUseID string `json:"parent_tool_use_id,omitempty"`
+
+	// Raw is the message's JSON as received from the CLI, including fields
+	// this type does not model.
+	Raw json.RawMessage `json:"-"`
 }
 
 func (*UserMessage) messageMarker() {}
 
+// Text returns the text of the message's text blocks, joined by newlines.
+func (m *UserMessage) Text() string {
+	var texts []string
+	for _, block := range m.Content {
+		if block.IsText() {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
 // AssistantMessage represents a response from the assistant.
 type AssistantMessage struct {
 	// Content contains the response blocks (text, thinking, tool use, etc.)
@@ -48,28 +71,155 @@ type AssistantMessage struct {
 	// Model is the model that generated this response.
 	Model string `json:"model"`
 
+	// ID is the API message ID. Partial messages of one response share it.
+	ID string `json:"id,omitempty"`
+
+	// StopReason is why the model stopped, such as "end_turn" or
+	// "tool_use". Empty while the response is still in progress.
+	StopReason string `json:"stop_reason,omitempty"`
+
+	// Usage is the token usage for this response.
+	Usage Usage `json:"usage"`
+
 	// ParentToolUseID links this message to a tool use (optional).
 	ParentToolUseID string `json:"parent_tool_use_id,omitempty"`
 
 	// Error indicates an error type if the response failed (optional).
-	// Possible values: "authentication_failed", "billing_error", "rate_limit",
-	// "invalid_request", "server_error", "unknown"
+	// Possible values are the APIErrorKind constants: "authentication_failed",
+	// "billing_error", "rate_limit", "invalid_request", "server_error",
+	// "unknown". Err returns it as an *APIError.
 	Error string `json:"error,omitempty"`
+
+	// Raw is the message's JSON as received from the CLI, including fields
+	// this type does not model.
+	Raw json.RawMessage `json:"-"`
 }
 
 func (*AssistantMessage) messageMarker() {}
 
+// Err returns an *APIError if the response failed, or nil. The error
+// message is the response's text, which the CLI fills with a description
+// of the failure.
+func (m *AssistantMessage) Err() error {
+	if m.Error == "" {
+		return nil
+	}
+
+	var text []string
+	for _, block := range m.Content {
+		if block.IsText() {
+			text = append(text, block.Text)
+		}
+	}
+	return &APIError{Kind: APIErrorKind(m.Error), Message: strings.Join(text, "\n")}
+}
+
 // SystemMessage represents a system-level message with metadata.
+// Subtypes with a dedicated type, such as SystemInitMessage, are not
+// delivered as SystemMessage.
 type SystemMessage struct {
 	// Subtype indicates the type of system message.
 	Subtype string `json:"subtype"`
 
-	// Data contains the message payload.
+	// Data contains the message payload. If the message has no "data"
+	// field, Data holds its top-level fields other than type and subtype.
 	Data map[string]any `json:"data"`
+
+	// Raw is the message's JSON as received from the CLI, including fields
+	// this type does not model.
+	Raw json.RawMessage `json:"-"`
 }
 
 func (*SystemMessage) messageMarker() {}
 
+// System message subtypes with their own message types.
+const (
+	// SystemSubtypeInit is sent when a session starts (SystemInitMessage).
+	SystemSubtypeInit = "init"
+
+	// SystemSubtypeCompactBoundary marks where the conversation was
+	// compacted (SystemCompactBoundaryMessage).
+	SystemSubtypeCompactBoundary = "compact_boundary"
+)
+
+// SystemInitMessage is sent by the CLI when a session starts. It describes
+// the session's tools, MCP servers, model and settings.
+// Other system messages are delivered as *SystemMessage.
+type SystemInitMessage struct {
+	// SessionID is the session identifier.
+	SessionID string `json:"session_id"`
+
+	// UUID is the unique identifier for this message.
+	UUID string `json:"uuid,omitempty"`
+
+	// APIKeySource describes where the CLI found its API key.
+	APIKeySource string `json:"apiKeySource"`
+
+	// Cwd is the CLI's working directory.
+	Cwd string `json:"cwd"`
+
+	// Tools lists the tools available to Claude.
+	Tools []string `json:"tools"`
+
+	// MCPServers reports the connection status of each MCP server.
+	MCPServers []MCPServerStatus `json:"mcp_servers"`
+
+	// Model is the model in use.
+	Model string `json:"model"`
+
+	// PermissionMode is the active permission mode.
+	PermissionMode PermissionMode `json:"permissionMode"`
+
+	// SlashCommands lists the names of the available slash commands.
+	SlashCommands []string `json:"slash_commands"`
+
+	// OutputStyle is the active output style.
+	OutputStyle string `json:"output_style,omitempty"`
+
+	// CLIVersion is the version of the Claude CLI.
+	CLIVersion string `json:"claude_code_version,omitempty"`
+
+	// Extra holds any fields not decoded above as raw JSON.
+	Extra map[string]json.RawMessage `json:"-"`
+
+	// Raw is the message's JSON as received from the CLI, including fields
+	// this type does not model.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (*SystemInitMessage) messageMarker() {}
+
+// SystemCompactBoundaryMessage marks the point where the conversation
+// was compacted. Messages before it have been summarized.
+type SystemCompactBoundaryMessage struct {
+	// SessionID is the session identifier.
+	SessionID string `json:"session_id"`
+
+	// UUID is the unique identifier for this message.
+	UUID string `json:"uuid,omitempty"`
+
+	// Metadata describes the compaction.
+	Metadata CompactMetadata `json:"compact_metadata"`
+
+	// Extra holds any fields not decoded above as raw JSON.
+	Extra map[string]json.RawMessage `json:"-"`
+
+	// Raw is the message's JSON as received from the CLI, including fields
+	// this type does not model.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (*SystemCompactBoundaryMessage) messageMarker() {}
+
+// CompactMetadata describes a conversation compaction.
+type CompactMetadata struct {
+	// Trigger is "manual" or "auto".
+	Trigger string `json:"trigger"`
+
+	// PreTokens is the token count before compaction.
+	PreTokens int `json:"pre_tokens"`
+}
+
 // ResultMessage contains the final result of a query including cost and usage.
 type ResultMessage struct {
 	// Subtype indicates the result type (e.g., "success").
@@ -93,18 +243,162 @@ type ResultMessage struct {
 	// TotalCostUSD is the total cost in USD (optional).
 	TotalCostUSD float64 `json:"total_cost_usd,omitempty"`
 
-	// Usage contains token usage information (optional).
-	Usage map[string]any `json:"usage,omitempty"`
+	// Usage is the total token usage for the query.
+	Usage Usage `json:"usage"`
+
+	// ModelUsage breaks down usage and cost by model name (optional).
+	ModelUsage map[string]ModelUsage `json:"modelUsage,omitempty"`
 
 	// Result contains the final text result (optional).
 	Result string `json:"result,omitempty"`
 
 	// StructuredOutput contains structured output if requested (optional).
 	StructuredOutput any `json:"structured_output,omitempty"`
+
+	// PermissionDenials lists the tool uses that were denied permission
+	// during the query (optional).
+	PermissionDenials []PermissionDenial `json:"permission_denials,omitempty"`
+
+	// Errors contains the CLI's error messages for error subtypes (optional).
+	Errors []string `json:"errors,omitempty"`
+
+	// Raw is the message's JSON as received from the CLI, including fields
+	// this type does not model.
+	Raw json.RawMessage `json:"-"`
+
+	// apiError is the API error reported by the turn's assistant message.
+	apiError *APIError
+}
+
+// Result message subtypes.
+const (
+	ResultSubtypeSuccess              = "success"
+	ResultSubtypeErrorMaxTurns        = "error_max_turns"
+	ResultSubtypeErrorMaxBudget       = "error_max_budget_usd"
+	ResultSubtypeErrorDuringExecution = "error_during_execution"
+)
+
+// Err returns the error the query ended with, or nil if it succeeded.
+// Use errors.Is with ErrMaxTurns, ErrBudgetExceeded and ErrExecution, or
+// errors.As with *APIError for API failures such as rate limits.
+//
+// A failure is an *APIError only when the turn's assistant message
+// reported one. Other failures, including error subtypes not listed
+// above, wrap ErrExecution with the subtype and the CLI's errors.
+func (m *ResultMessage) Err() error {
+	switch m.Subtype {
+	case ResultSubtypeErrorMaxTurns:
+		return fmt.Errorf("%w after %d turns", ErrMaxTurns, m.NumTurns)
+	case ResultSubtypeErrorMaxBudget:
+		return fmt.Errorf("%w: spent $%.4f", ErrBudgetExceeded, m.TotalCostUSD)
+	case ResultSubtypeErrorDuringExecution:
+		if len(m.Errors) > 0 {
+			return fmt.Errorf("%w: %s", ErrExecution, strings.Join(m.Errors, "; "))
+		}
+		return ErrExecution
+	}
+
+	if !m.IsError && !strings.HasPrefix(m.Subtype, "error") {
+		return nil
+	}
+
+	if m.apiError != nil {
+		err := &APIError{Kind: m.apiError.Kind, Message: m.Result}
+		if err.Message == "" {
+			err.Message = m.apiError.Message
+		}
+		return err
+	}
+
+	details := m.Errors
+	if len(details) == 0 && m.Result != "" {
+		details = []string{m.Result}
+	}
+	if len(details) == 0 {
+		return fmt.Errorf("%w (%s)", ErrExecution, m.Subtype)
+	}
+	return fmt.Errorf("%w (%s): %s", ErrExecution, m.Subtype, strings.Join(details, "; "))
+}
+
+// PermissionDenial describes a tool use that was denied permission.
+type PermissionDenial struct {
+	ToolName  string         `json:"tool_name"`
+	ToolUseID string         `json:"tool_use_id"`
+	ToolInput map[string]any `json:"tool_input"`
 }
 
 func (*ResultMessage) messageMarker() {}
 
+// Usage reports token usage from the Anthropic API.
+type Usage struct {
+	// InputTokens is the number of uncached input tokens.
+	InputTokens int `json:"input_tokens"`
+
+	// OutputTokens is the number of output tokens.
+	OutputTokens int `json:"output_tokens"`
+
+	// CacheCreationInputTokens is the number of input tokens written to
+	// the prompt cache.
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+
+	// CacheReadInputTokens is the number of input tokens read from the
+	// prompt cache.
+	CacheReadInputTokens int `json:"cache_read_input_tokens"`
+
+	// ServerToolUse counts server-side tool requests.
+	ServerToolUse ServerToolUsage `json:"server_tool_use"`
+
+	// ServiceTier is the API service tier used (optional).
+	ServiceTier string `json:"service_tier,omitempty"`
+}
+
+// ServerToolUsage counts requests made by server-side tools.
+type ServerToolUsage struct {
+	// WebSearchRequests is the number of web searches.
+	WebSearchRequests int `json:"web_search_requests"`
+
+	// WebFetchRequests is the number of web fetches.
+	WebFetchRequests int `json:"web_fetch_requests"`
+}
+
+// ModelUsage reports usage and cost for a single model in a query.
+type ModelUsage struct {
+	// InputTokens is the number of uncached input tokens.
+	InputTokens int `json:"inputTokens"`
+
+	// OutputTokens is the number of output tokens.
+	OutputTokens int `json:"outputTokens"`
+
+	// CacheCreationInputTokens is the number of input tokens written to
+	// the prompt cache.
+	CacheCreationInputTokens int `json:"cacheCreationInputTokens"`
+
+	// CacheReadInputTokens is the number of input tokens read from the
+	// prompt cache.
+	CacheReadInputTokens int `json:"cacheReadInputTokens"`
+
+	// WebSearchRequests is the number of web searches.
+	WebSearchRequests int `json:"webSearchRequests"`
+
+	// CostUSD is the cost of this model's usage in USD.
+	CostUSD float64 `json:"costUSD"`
+
+	// ContextWindow is the model's context window size in tokens.
+	ContextWindow int `json:"contextWindow,omitempty"`
+}
+
+// UnknownMessage is a message whose type this version of the SDK does not
+// recognize, such as a message kind added in a newer CLI.
+type UnknownMessage struct {
+	// Type is the message's "type" field.
+	Type string `json:"type"`
+
+	// Raw is the message's JSON as received from the CLI.
+	Raw json.RawMessage `json:"-"`
+}
+
+func (*UnknownMessage) messageMarker() {}
+
 // StreamEvent represents a streaming event for partial message updates.
 type StreamEvent struct {
 	// UUID is the unique identifier for this event.
@@ -113,11 +407,50 @@ type StreamEvent struct {
 	// SessionID is the session identifier.
 	SessionID string `json:"session_id"`
 
-	// Event contains the raw Anthropic API stream event.
-	Event map[string]any `json:"event"`
+	// Event is the Anthropic API stream event. The fields below are
+	// decoded from it. Use EventMap for the map[string]any form Event had
+	// before it was raw JSON.
+	Event json.RawMessage `json:"event"`
 
 	// ParentToolUseID links this event to a tool use (optional).
 	ParentToolUseID string `json:"parent_tool_use_id,omitempty"`
+
+	// Type is the stream event type decoded from Event.
+	Type StreamEventType `json:"-"`
+
+	// Index is the content block index for content_block_start,
+	// content_block_delta and content_block_stop events.
+	Index int `json:"-"`
+
+	// Message is the initial message of a message_start event. Its
+	// content is usually empty; blocks arrive in later events.
+	Message *AssistantMessage `json:"-"`
+
+	// ContentBlock is the block opened by a content_block_start event.
+	ContentBlock *ContentBlock `json:"-"`
+
+	// Delta is the change carried by a content_block_delta event.
+	Delta *StreamDelta `json:"-"`
+
+	// StopReason is set by a message_delta event when the model stops.
+	StopReason string `json:"-"`
+
+	// Usage is the cumulative usage reported by a message_delta event.
+	Usage *Usage `json:"-"`
+
+	// Raw is the message's JSON as received from the CLI, including fields
+	// this type does not model.
+	Raw json.RawMessage `json:"-"`
 }
 
 func (*StreamEvent) messageMarker() {}
+
+// EventMap decodes Event into a map. It returns nil if Event is not a
+// JSON object.
+func (e *StreamEvent) EventMap() map[string]any {
+	var event map[string]any
+	if json.Unmarshal(e.Event, &event) != nil {
+		return nil
+	}
+	return event
+}
@@ -2,6 +2,8 @@ package claude
 
 import (
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -11,8 +13,11 @@ func TestMessageInterface(t *testing.T) {
 		var _ Message = &UserMessage{}
 		var _ Message = &AssistantMessage{}
 		var _ Message = &SystemMessage{}
+		var _ Message = &SystemInitMessage{}
+		var _ Message = &SystemCompactBoundaryMessage{}
 		var _ Message = &ResultMessage{}
 		var _ Message = &StreamEvent{}
+		var _ Message = &UnknownMessage{}
 	})
 
 	t.Run("messageMarker methods are callable", func(t *testing.T) {
@@ -21,25 +26,50 @@ func TestMessageInterface(t *testing.T) {
 		(&UserMessage{}).messageMarker()
 		(&AssistantMessage{}).messageMarker()
 		(&SystemMessage{}).messageMarker()
+		(&SystemInitMessage{}).messageMarker()
+		(&SystemCompactBoundaryMessage{}).messageMarker()
 		(&ResultMessage{}).messageMarker()
 		(&StreamEvent{}).messageMarker()
+		(&UnknownMessage{}).messageMarker()
 	})
 }
 
 func TestUserMessage(t *testing.T) {
-	t.Run("create with string content", func(t *testing.T) {
+	t.Run("create with text content", func(t *testing.T) {
 		msg := &UserMessage{
-			Content: "Hello, Claude!",
+			Content: []*ContentBlock{NewTextBlock("Hello, Claude!")},
 		}
 
-		if msg.Content != "Hello, Claude!" {
-			t.Errorf("Content = %q, want %q", msg.Content, "Hello, Claude!")
+		if msg.Text() != "Hello, Claude!" {
+			t.Errorf("Text() = %q, want %q", msg.Text(), "Hello, Claude!")
+		}
+	})
+
+	t.Run("Text joins text blocks and skips others", func(t *testing.T) {
+		msg := &UserMessage{
+			Content: []*ContentBlock{
+				NewTextBlock("first"),
+				NewToolResultBlock("tool-1", "output", false),
+				NewTextBlock("second"),
+			},
+		}
+
+		if msg.Text() != "first\nsecond" {
+			t.Errorf("Text() = %q, want %q", msg.Text(), "first\nsecond")
+		}
+	})
+
+	t.Run("Text is empty without text blocks", func(t *testing.T) {
+		msg := &UserMessage{}
+
+		if msg.Text() != "" {
+			t.Errorf("Text() = %q, want empty", msg.Text())
 		}
 	})
 
 	t.Run("with optional fields", func(t *testing.T) {
 		msg := &UserMessage{
-			Content:         "Hello",
+			Content:         []*ContentBlock{NewTextBlock("Hello")},
 			UUID:            "uuid-123",
 			ParentToolUseID: "tool-456",
 		}
@@ -175,13 +205,13 @@ func TestResultMessage(t *testing.T) {
 			NumTurns:         2,
 			SessionID:        "sess-1",
 			TotalCostUSD:     0.05,
-			Usage:            map[string]any{"input_tokens": 100, "output_tokens": 50},
+			Usage:            Usage{InputTokens: 100, OutputTokens: 50},
 			Result:           "Final result text",
 			StructuredOutput: map[string]any{"key": "value"},
 		}
 
-		if msg.Usage["input_tokens"] != 100 {
-			t.Errorf("Usage[input_tokens] = %v, want 100", msg.Usage["input_tokens"])
+		if msg.Usage.InputTokens != 100 {
+			t.Errorf("Usage.InputTokens = %d, want 100", msg.Usage.InputTokens)
 		}
 		if msg.Result != "Final result text" {
 			t.Errorf("Result = %q, want %q", msg.Result, "Final result text")
@@ -192,12 +222,124 @@ func TestResultMessage(t *testing.T) {
 	})
 }
 
+func TestResultMessageErr(t *testing.T) {
+	t.Run("success has no error", func(t *testing.T) {
+		msg := &ResultMessage{Subtype: ResultSubtypeSuccess}
+
+		if err := msg.Err(); err != nil {
+			t.Errorf("Err() = %v, want nil", err)
+		}
+	})
+
+	t.Run("error subtypes match sentinel errors", func(t *testing.T) {
+		tests := []struct {
+			subtype string
+			want    error
+		}{
+			{ResultSubtypeErrorMaxTurns, ErrMaxTurns},
+			{ResultSubtypeErrorMaxBudget, ErrBudgetExceeded},
+			{ResultSubtypeErrorDuringExecution, ErrExecution},
+		}
+		for _, tt := range tests {
+			msg := &ResultMessage{Subtype: tt.subtype, IsError: true}
+
+			if err := msg.Err(); !errors.Is(err, tt.want) {
+				t.Errorf("Err() for %s = %v, want %v", tt.subtype, err, tt.want)
+			}
+		}
+	})
+
+	t.Run("execution error includes CLI errors", func(t *testing.T) {
+		msg := &ResultMessage{
+			Subtype: ResultSubtypeErrorDuringExecution,
+			IsError: true,
+			Errors:  []string{"tool crashed"},
+		}
+
+		if err := msg.Err(); err == nil || !strings.Contains(err.Error(), "tool crashed") {
+			t.Errorf("Err() = %v, want error mentioning 'tool crashed'", err)
+		}
+	})
+
+	t.Run("failed success result is an APIError", func(t *testing.T) {
+		msg := &ResultMessage{
+			Subtype:  ResultSubtypeSuccess,
+			IsError:  true,
+			Result:   "Invalid API key",
+			apiError: &APIError{Kind: APIErrorAuthenticationFailed},
+		}
+
+		var apiErr *APIError
+		if !errors.As(msg.Err(), &apiErr) {
+			t.Fatalf("Err() = %v, want *APIError", msg.Err())
+		}
+		if apiErr.Kind != APIErrorAuthenticationFailed || apiErr.Message != "Invalid API key" {
+			t.Errorf("APIError = %+v, want authentication_failed 'Invalid API key'", apiErr)
+		}
+	})
+
+	t.Run("failed result without assistant error is an execution error", func(t *testing.T) {
+		msg := &ResultMessage{Subtype: ResultSubtypeSuccess, IsError: true, Result: "something broke"}
+
+		err := msg.Err()
+		var apiErr *APIError
+		if !errors.Is(err, ErrExecution) || errors.As(err, &apiErr) {
+			t.Errorf("Err() = %v, want %v", err, ErrExecution)
+		}
+		if err == nil || !strings.Contains(err.Error(), "something broke") {
+			t.Errorf("Err() = %v, want error mentioning the result", err)
+		}
+	})
+
+	t.Run("unlisted error subtype is an execution error", func(t *testing.T) {
+		msg := &ResultMessage{
+			Subtype: "error_max_structured_output_retries",
+			IsError: true,
+			Errors:  []string{"output did not match schema"},
+		}
+
+		err := msg.Err()
+		var apiErr *APIError
+		if !errors.Is(err, ErrExecution) || errors.As(err, &apiErr) {
+			t.Fatalf("Err() = %v, want %v", err, ErrExecution)
+		}
+		if !strings.Contains(err.Error(), "error_max_structured_output_retries") || !strings.Contains(err.Error(), "output did not match schema") {
+			t.Errorf("Err() = %v, want subtype and errors", err)
+		}
+	})
+}
+
+func TestAssistantMessageErr(t *testing.T) {
+	t.Run("nil without error", func(t *testing.T) {
+		msg := &AssistantMessage{Content: []*ContentBlock{NewTextBlock("ok")}}
+
+		if err := msg.Err(); err != nil {
+			t.Errorf("Err() = %v, want nil", err)
+		}
+	})
+
+	t.Run("APIError with response text", func(t *testing.T) {
+		msg := &AssistantMessage{
+			Content: []*ContentBlock{NewTextBlock("API Error: Rate limit reached")},
+			Error:   "rate_limit",
+		}
+
+		var apiErr *APIError
+		if !errors.As(msg.Err(), &apiErr) {
+			t.Fatalf("Err() = %v, want *APIError", msg.Err())
+		}
+		if apiErr.Kind != APIErrorRateLimit || apiErr.Message != "API Error: Rate limit reached" {
+			t.Errorf("APIError = %+v, want rate_limit with response text", apiErr)
+		}
+	})
+}
+
 func TestStreamEvent(t *testing.T) {
 	t.Run("create stream event", func(t *testing.T) {
 		msg := &StreamEvent{
 			UUID:      "event-123",
 			SessionID: "session-456",
-			Event:     map[string]any{"type": "content_block_delta"},
+			Event:     json.RawMessage(`{"type":"content_block_delta"}`),
 		}
 
 		if msg.UUID != "event-123" {
@@ -206,8 +348,21 @@ func TestStreamEvent(t *testing.T) {
 		if msg.SessionID != "session-456" {
 			t.Errorf("SessionID = %q, want %q", msg.SessionID, "session-456")
 		}
-		if msg.Event["type"] != "content_block_delta" {
-			t.Errorf("Event[type] = %v, want 'content_block_delta'", msg.Event["type"])
+		if string(msg.Event) != `{"type":"content_block_delta"}` {
+			t.Errorf("Event = %s, want the content_block_delta event", msg.Event)
+		}
+	})
+
+	t.Run("EventMap decodes the event", func(t *testing.T) {
+		msg := &StreamEvent{Event: json.RawMessage(`{"type":"content_block_delta","index":1}`)}
+
+		event := msg.EventMap()
+
+		if event["type"] != "content_block_delta" || event["index"] != float64(1) {
+			t.Errorf("EventMap() = %v, want the content_block_delta event", event)
+		}
+		if (&StreamEvent{}).EventMap() != nil {
+			t.Error("EventMap() of an empty event should be nil")
 		}
 	})
 
@@ -215,7 +370,7 @@ func TestStreamEvent(t *testing.T) {
 		msg := &StreamEvent{
 			UUID:            "event-1",
 			SessionID:       "sess-1",
-			Event:           map[string]any{},
+			Event:           json.RawMessage(`{}`),
 			ParentToolUseID: "tool-999",
 		}
 
@@ -227,7 +382,7 @@ func TestStreamEvent(t *testing.T) {
 
 func TestMessageJSON(t *testing.T) {
 	t.Run("marshal UserMessage", func(t *testing.T) {
-		msg := &UserMessage{Content: "Hello"}
+		msg := &UserMessage{Content: []*ContentBlock{NewTextBlock("Hello")}}
 		data, err := json.Marshal(msg)
 		if err != nil {
 			t.Fatalf("Marshal failed: %v", err)
@@ -238,8 +393,12 @@ func TestMessageJSON(t *testing.T) {
 			t.Fatalf("Unmarshal failed: %v", err)
 		}
 
-		if parsed["content"] != "Hello" {
-			t.Errorf("JSON content = %v, want 'Hello'", parsed["content"])
+		content, ok := parsed["content"].([]any)
+		if !ok || len(content) != 1 {
+			t.Fatalf("JSON content = %v, want one block", parsed["content"])
+		}
+		if block, _ := content[0].(map[string]any); block["text"] != "Hello" {
+			t.Errorf("JSON content[0] = %v, want text 'Hello'", content[0])
 		}
 	})
 
@@ -1,6 +1,7 @@
 package claude
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -58,7 +59,8 @@ type config struct {
 	maxThinkingTokens int
 
 	// MCP
-	mcpConfig string
+	mcpConfig  string
+	mcpServers map[string]*SDKMCPServer
 
 	// Agents
 	agents map[string]AgentDefinition
@@ -87,6 +89,9 @@ type config struct {
 	// Internal callback for tool permissions
 	canUseTool CanUseToolFunc
 
+	// Maximum number of hook/permission/MCP callbacks running at once
+	callbackConcurrency int
+
 	// Additional CLI options
 	extraArgs     map[string]string
 	addDirs       []string
@@ -95,6 +100,16 @@ type config struct {
 	betas         []string
 	maxBufferSize int
 
+	// Message buffering and decoding
+	messageBufferSize int
+	overflowPolicy    OverflowPolicy
+	strictDecoding    bool
+
+	// Lifecycle
+	sessionContext      context.Context
+	initializeTimeout   time.Duration
+	shutdownGracePeriod time.Duration
+
 	// Advanced options
 	outputFormat           *OutputFormat
 	sandbox                *SandboxSettings
@@ -102,7 +117,8 @@ type config struct {
 	forkSession            bool
 
 	// Callbacks
-	stderrCallback func(string)
+	stderrCallback       func(string)
+	messageErrorCallback func(error)
 }
 
 // Option is a function that configures the client.
@@ -126,6 +142,65 @@ func (c *config) generateCallbackID() string {
 	return id
 }
 
+// defaultMessageBufferSize is the default capacity of message channels.
+const defaultMessageBufferSize = 100
+
+// messageBuffer returns the capacity to use for message channels.
+func (c *config) messageBuffer() int {
+	if c.messageBufferSize > 0 {
+		return c.messageBufferSize
+	}
+	return defaultMessageBufferSize
+}
+
+// defaultInitializeTimeout is how long Connect waits for the CLI to
+// answer the initialize request.
+const defaultInitializeTimeout = 60 * time.Second
+
+// initializeWait returns how long Connect waits for the initialize response.
+func (c *config) initializeWait() time.Duration {
+	if c.initializeTimeout > 0 {
+		return c.initializeTimeout
+	}
+	return defaultInitializeTimeout
+}
+
+// defaultShutdownGracePeriod is how long Close waits at each shutdown stage.
+const defaultShutdownGracePeriod = 5 * time.Second
+
+// shutdownGrace returns the grace period for each shutdown stage.
+func (c *config) shutdownGrace() time.Duration {
+	if c.shutdownGracePeriod > 0 {
+		return c.shutdownGracePeriod
+	}
+	return defaultShutdownGracePeriod
+}
+
+// defaultCallbackConcurrency is the default number of callback workers.
+const defaultCallbackConcurrency = 8
+
+// callbackWorkers returns the number of callback workers to run.
+func (c *config) callbackWorkers() int {
+	if c.callbackConcurrency > 0 {
+		return c.callbackConcurrency
+	}
+	return defaultCallbackConcurrency
+}
+
+// hookTimeout returns the timeout registered for a hook callback, or zero.
+func (c *config) hookTimeout(callbackID string) time.Duration {
+	for _, matchers := range c.hooks {
+		for _, m := range matchers {
+			for _, id := range m.callbackIDs {
+				if id == callbackID {
+					return m.timeout
+				}
+			}
+		}
+	}
+	return 0
+}
+
 // WithModel sets the model to use (e.g., "claude-sonnet-4-5").
 func WithModel(model string) Option {
 	return func(c *config) {
@@ -232,6 +307,20 @@ func WithMCPConfig(path string) Option {
 	}
 }
 
+// WithMCPServers registers in-process MCP servers created with NewSDKMCPServer.
+// Their tools are available to Claude as mcp__<server>__<tool>.
+// Servers with the same name replace earlier registrations.
+func WithMCPServers(servers ...*SDKMCPServer) Option {
+	return func(c *config) {
+		if c.mcpServers == nil {
+			c.mcpServers = make(map[string]*SDKMCPServer)
+		}
+		for _, s := range servers {
+			c.mcpServers[s.name] = s
+		}
+	}
+}
+
 // WithEnableFileCheckpointing enables tracking of file changes during the session.
 // When enabled, files can be rewound to their state at any user message
 // using Client.RewindFiles().
@@ -261,6 +350,8 @@ type hookConfig struct {
 type HookOption func(*hookConfig)
 
 // HookTimeout sets a timeout for hook execution.
+// The timeout is sent to the CLI and also applied as a deadline on the
+// context passed to the hook.
 func HookTimeout(d time.Duration) HookOption {
 	return func(hc *hookConfig) {
 		hc.timeout = d
@@ -298,7 +389,19 @@ const (
 )
 
 // CanUseToolFunc is a callback for custom tool permission logic.
-type CanUseToolFunc func(toolName string, input map[string]any) (PermissionResult, error)
+// It is invoked when the CLI asks the SDK whether a tool may be used.
+type CanUseToolFunc func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error)
+
+// ToolPermissionContext provides context information to permission callbacks.
+type ToolPermissionContext struct {
+	// Suggestions are permission updates suggested by the CLI (optional),
+	// such as a rule that would allow this tool use from now on. Return
+	// them in PermissionResult.UpdatedPermissions to apply them.
+	Suggestions []PermissionUpdate
+
+	// BlockedPath is the path that triggered the permission check (optional).
+	BlockedPath string
+}
 
 // PermissionResult represents the result of a permission check.
 type PermissionResult struct {
@@ -308,17 +411,109 @@ type PermissionResult struct {
 	// Message is an optional message (used when denying).
 	Message string
 
+	// Interrupt stops the current turn (used when denying).
+	Interrupt bool
+
 	// UpdatedInput allows modifying the tool input (when allowing).
 	UpdatedInput map[string]any
+
+	// UpdatedPermissions are permission updates to apply (when allowing),
+	// such as the suggestions from ToolPermissionContext.
+	UpdatedPermissions []PermissionUpdate
+}
+
+// PermissionUpdateType identifies the change a PermissionUpdate makes.
+type PermissionUpdateType string
+
+const (
+	// PermissionUpdateAddRules adds Rules with Behavior.
+	PermissionUpdateAddRules PermissionUpdateType = "addRules"
+
+	// PermissionUpdateReplaceRules replaces the rules with Behavior by Rules.
+	PermissionUpdateReplaceRules PermissionUpdateType = "replaceRules"
+
+	// PermissionUpdateRemoveRules removes Rules with Behavior.
+	PermissionUpdateRemoveRules PermissionUpdateType = "removeRules"
+
+	// PermissionUpdateSetMode sets the permission mode to Mode.
+	PermissionUpdateSetMode PermissionUpdateType = "setMode"
+
+	// PermissionUpdateAddDirectories gives access to Directories.
+	PermissionUpdateAddDirectories PermissionUpdateType = "addDirectories"
+
+	// PermissionUpdateRemoveDirectories removes access to Directories.
+	PermissionUpdateRemoveDirectories PermissionUpdateType = "removeDirectories"
+)
+
+// PermissionBehavior is what a permission rule does with the tool uses it
+// matches.
+type PermissionBehavior string
+
+const (
+	// PermissionBehaviorAllow allows matching tool uses.
+	PermissionBehaviorAllow PermissionBehavior = "allow"
+
+	// PermissionBehaviorDeny denies matching tool uses.
+	PermissionBehaviorDeny PermissionBehavior = "deny"
+
+	// PermissionBehaviorAsk asks for permission for matching tool uses.
+	PermissionBehaviorAsk PermissionBehavior = "ask"
+)
+
+// PermissionUpdateDestination is where a permission update is stored.
+type PermissionUpdateDestination string
+
+const (
+	// PermissionDestinationUserSettings stores the update in the user settings.
+	PermissionDestinationUserSettings PermissionUpdateDestination = "userSettings"
+
+	// PermissionDestinationProjectSettings stores the update in the project settings.
+	PermissionDestinationProjectSettings PermissionUpdateDestination = "projectSettings"
+
+	// PermissionDestinationLocalSettings stores the update in the local project settings.
+	PermissionDestinationLocalSettings PermissionUpdateDestination = "localSettings"
+
+	// PermissionDestinationSession applies the update to the current session only.
+	PermissionDestinationSession PermissionUpdateDestination = "session"
+)
+
+// PermissionRule matches tool uses by tool name and, optionally, by rule
+// content such as a command prefix for Bash.
+type PermissionRule struct {
+	ToolName    string `json:"toolName"`
+	RuleContent string `json:"ruleContent,omitempty"`
+}
+
+// PermissionUpdate is a change to the permission settings. Type
+// determines which of the other fields apply.
+type PermissionUpdate struct {
+	Type        PermissionUpdateType        `json:"type"`
+	Rules       []PermissionRule            `json:"rules,omitempty"`
+	Behavior    PermissionBehavior          `json:"behavior,omitempty"`
+	Mode        PermissionMode              `json:"mode,omitempty"`
+	Directories []string                    `json:"directories,omitempty"`
+	Destination PermissionUpdateDestination `json:"destination,omitempty"`
 }
 
 // WithCanUseTool sets a callback for custom tool permission logic.
+// The CLI is started with permission prompts routed to the SDK, so the
+// callback is consulted whenever a tool would otherwise require approval.
 func WithCanUseTool(fn CanUseToolFunc) Option {
 	return func(c *config) {
 		c.canUseTool = fn
 	}
 }
 
+// WithCallbackConcurrency sets how many hook, permission and SDK MCP
+// callbacks may run at once (default 8). Callbacks run off the
+// message-reading goroutine, so a slow callback does not delay other
+// messages until all workers are busy.
+func WithCallbackConcurrency(n int) Option {
+	return func(c *config) {
+		c.callbackConcurrency = n
+	}
+}
+
 // WithExtraArgs passes arbitrary CLI flags.
 // Keys are flag names (without --), values are flag values.
 // Use empty string for boolean flags.
@@ -359,13 +554,89 @@ func WithBetas(betas ...string) Option {
 	}
 }
 
-// WithMaxBufferSize sets the maximum buffer size for stdout.
+// WithMaxBufferSize limits the size in bytes of a single message from the
+// CLI. By default messages of any size are read. A longer message is
+// skipped without ending the session, and a *MessageTooLargeError is
+// passed to the callback set with WithMessageErrorCallback.
 func WithMaxBufferSize(size int) Option {
 	return func(c *config) {
 		c.maxBufferSize = size
 	}
 }
 
+// WithMessageBufferSize sets how many messages are buffered between the
+// CLI and the consumer of Messages() (default 100).
+func WithMessageBufferSize(size int) Option {
+	return func(c *config) {
+		c.messageBufferSize = size
+	}
+}
+
+// WithSessionContext ties the session lifetime to ctx. When ctx is done
+// the client is closed as if Close had been called, and Err reports the
+// context's error.
+//
+// Without this option a session lasts until Close is called. The context
+// passed to Connect only bounds connecting and the initial handshake, so a
+// connect timeout does not end the session later.
+func WithSessionContext(ctx context.Context) Option {
+	return func(c *config) {
+		c.sessionContext = ctx
+	}
+}
+
+// WithInitializeTimeout sets how long Connect waits for the CLI to answer
+// the initialize handshake (default 60 seconds). Connect fails with
+// ErrCLIConnection if the CLI does not answer in time.
+func WithInitializeTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.initializeTimeout = d
+	}
+}
+
+// WithShutdownGracePeriod sets how long Close waits for the CLI to exit.
+// Close first closes stdin and waits up to d for the CLI to finish on its
+// own, then sends SIGTERM and waits up to d again, and finally sends
+// SIGKILL. Signals go to the CLI's whole process group, so MCP servers and
+// shells it started are stopped too. The default is 5 seconds.
+func WithShutdownGracePeriod(d time.Duration) Option {
+	return func(c *config) {
+		c.shutdownGracePeriod = d
+	}
+}
+
+// OverflowPolicy controls what happens when the message buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock stops reading CLI output until the consumer catches up.
+	// No messages are lost. This is the default.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropStreamEvents drops partial StreamEvent messages while the
+	// buffer is full. All other messages still block, so assistant messages,
+	// results and control requests are never lost.
+	OverflowDropStreamEvents
+)
+
+// WithOverflowPolicy sets the behavior when the message buffer is full.
+// Dropped messages are counted by Client.DroppedMessages.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(c *config) {
+		c.overflowPolicy = policy
+	}
+}
+
+// WithStrictDecoding makes malformed CLI output and unknown message types
+// end the session instead of being tolerated. Client.Err then reports a
+// *JSONDecodeError with the offending line. By default malformed lines are
+// skipped and unknown message types are delivered as *UnknownMessage.
+func WithStrictDecoding(enabled bool) Option {
+	return func(c *config) {
+		c.strictDecoding = enabled
+	}
+}
+
 // WithOutputFormat configures structured output with JSON schema validation.
 // The schema must be a valid JSON schema that Claude's output will conform to.
 func WithOutputFormat(format *OutputFormat) Option {
@@ -431,6 +702,21 @@ func WithPlugins(plugins ...PluginConfig) Option {
 	}
 }
 
+// MessageErrorCallback is a function that receives errors for messages
+// from the CLI that were skipped while the session continued.
+type MessageErrorCallback func(err error)
+
+// WithMessageErrorCallback sets a callback for messages from the CLI that
+// are skipped: a *MessageTooLargeError for messages over the
+// WithMaxBufferSize limit, or a *JSONDecodeError for malformed lines when
+// strict decoding is off. It is called from the goroutine reading the
+// CLI's output, so it should return quickly.
+func WithMessageErrorCallback(callback MessageErrorCallback) Option {
+	return func(c *config) {
+		c.messageErrorCallback = callback
+	}
+}
+
 // StderrCallback is a function that receives each line of stderr output.
 type StderrCallback func(line string)
 
@@ -1,6 +1,7 @@
 package claude
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -192,6 +193,34 @@ func TestOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("WithCallbackConcurrency sets worker count", func(t *testing.T) {
+		cfg := &config{}
+		if cfg.callbackWorkers() != defaultCallbackConcurrency {
+			t.Errorf("default callbackWorkers() = %d, want %d", cfg.callbackWorkers(), defaultCallbackConcurrency)
+		}
+
+		applyOptions(cfg, WithCallbackConcurrency(3))
+
+		if cfg.callbackWorkers() != 3 {
+			t.Errorf("callbackWorkers() = %d, want 3", cfg.callbackWorkers())
+		}
+	})
+
+	t.Run("WithMCPServers registers SDK servers by name", func(t *testing.T) {
+		cfg := &config{}
+		applyOptions(cfg, WithMCPServers(
+			NewSDKMCPServer("one", "1.0.0"),
+			NewSDKMCPServer("two", "1.0.0"),
+		))
+
+		if len(cfg.mcpServers) != 2 {
+			t.Fatalf("mcpServers length = %d, want 2", len(cfg.mcpServers))
+		}
+		if cfg.mcpServers["two"] == nil {
+			t.Error("mcpServers should contain 'two'")
+		}
+	})
+
 	t.Run("WithEnableFileCheckpointing enables checkpointing", func(t *testing.T) {
 		cfg := &config{}
 		applyOptions(cfg, WithEnableFileCheckpointing(true))
@@ -311,6 +340,69 @@ func TestOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("WithMessageBufferSize sets channel capacity", func(t *testing.T) {
+		cfg := &config{}
+		if cfg.messageBuffer() != defaultMessageBufferSize {
+			t.Errorf("default messageBuffer() = %d, want %d", cfg.messageBuffer(), defaultMessageBufferSize)
+		}
+
+		applyOptions(cfg, WithMessageBufferSize(1000))
+
+		if cfg.messageBuffer() != 1000 {
+			t.Errorf("messageBuffer() = %d, want 1000", cfg.messageBuffer())
+		}
+	})
+
+	t.Run("WithOverflowPolicy sets policy", func(t *testing.T) {
+		cfg := &config{}
+		if cfg.overflowPolicy != OverflowBlock {
+			t.Errorf("default overflowPolicy = %v, want OverflowBlock", cfg.overflowPolicy)
+		}
+
+		applyOptions(cfg, WithOverflowPolicy(OverflowDropStreamEvents))
+
+		if cfg.overflowPolicy != OverflowDropStreamEvents {
+			t.Errorf("overflowPolicy = %v, want OverflowDropStreamEvents", cfg.overflowPolicy)
+		}
+	})
+
+	t.Run("WithSessionContext sets session context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cfg := &config{}
+		applyOptions(cfg, WithSessionContext(ctx))
+
+		if cfg.sessionContext != ctx {
+			t.Error("sessionContext not set")
+		}
+	})
+
+	t.Run("WithInitializeTimeout sets handshake timeout", func(t *testing.T) {
+		cfg := &config{}
+		if cfg.initializeWait() != defaultInitializeTimeout {
+			t.Errorf("default initializeWait() = %v, want %v", cfg.initializeWait(), defaultInitializeTimeout)
+		}
+
+		applyOptions(cfg, WithInitializeTimeout(5*time.Second))
+
+		if cfg.initializeWait() != 5*time.Second {
+			t.Errorf("initializeWait() = %v, want 5s", cfg.initializeWait())
+		}
+	})
+
+	t.Run("WithShutdownGracePeriod sets grace period", func(t *testing.T) {
+		cfg := &config{}
+		if cfg.shutdownGrace() != defaultShutdownGracePeriod {
+			t.Errorf("default shutdownGrace() = %v, want %v", cfg.shutdownGrace(), defaultShutdownGracePeriod)
+		}
+
+		applyOptions(cfg, WithShutdownGracePeriod(time.Second))
+
+		if cfg.shutdownGrace() != time.Second {
+			t.Errorf("shutdownGrace() = %v, want 1s", cfg.shutdownGrace())
+		}
+	})
+
 	t.Run("WithMaxBufferSize sets buffer size", func(t *testing.T) {
 		cfg := &config{}
 		applyOptions(cfg, WithMaxBufferSize(2048000))
@@ -334,7 +426,7 @@ func TestHookOptions(t *testing.T) {
 
 func TestWithCanUseTool(t *testing.T) {
 	t.Run("sets canUseTool callback", func(t *testing.T) {
-		fn := func(toolName string, input map[string]any) (PermissionResult, error) {
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
 			return PermissionResult{Allow: true}, nil
 		}
 
@@ -348,7 +440,7 @@ func TestWithCanUseTool(t *testing.T) {
 
 	t.Run("callback is invocable", func(t *testing.T) {
 		called := false
-		fn := func(toolName string, input map[string]any) (PermissionResult, error) {
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
 			called = true
 			if toolName != "Bash" {
 				t.Errorf("toolName = %q, want 'Bash'", toolName)
@@ -362,7 +454,7 @@ func TestWithCanUseTool(t *testing.T) {
 		cfg := &config{}
 		applyOptions(cfg, WithCanUseTool(fn))
 
-		result, err := cfg.canUseTool("Bash", map[string]any{"command": "ls"})
+		result, err := cfg.canUseTool(context.Background(), "Bash", map[string]any{"command": "ls"}, &ToolPermissionContext{})
 		if err != nil {
 			t.Errorf("canUseTool error = %v, want nil", err)
 		}
@@ -378,7 +470,7 @@ func TestWithCanUseTool(t *testing.T) {
 	})
 
 	t.Run("callback can deny with updated input", func(t *testing.T) {
-		fn := func(toolName string, input map[string]any) (PermissionResult, error) {
+		fn := func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
 			return PermissionResult{
 				Allow:        false,
 				Message:      "denied",
@@ -389,7 +481,7 @@ func TestWithCanUseTool(t *testing.T) {
 		cfg := &config{}
 		applyOptions(cfg, WithCanUseTool(fn))
 
-		result, _ := cfg.canUseTool("Bash", map[string]any{"command": "rm -rf /"})
+		result, _ := cfg.canUseTool(context.Background(), "Bash", map[string]any{"command": "rm -rf /"}, &ToolPermissionContext{})
 		if result.Allow {
 			t.Error("result.Allow should be false")
 		}
@@ -537,6 +629,26 @@ func TestWithPlugins(t *testing.T) {
 	})
 }
 
+func TestWithMessageErrorCallback(t *testing.T) {
+	t.Run("sets message error callback", func(t *testing.T) {
+		var received error
+		cfg := &config{}
+		applyOptions(cfg, WithMessageErrorCallback(func(err error) {
+			received = err
+		}))
+
+		if cfg.messageErrorCallback == nil {
+			t.Fatal("messageErrorCallback should not be nil")
+		}
+
+		want := &MessageTooLargeError{Size: 10, Limit: 5}
+		cfg.messageErrorCallback(want)
+		if received != want {
+			t.Errorf("received = %v, want %v", received, want)
+		}
+	})
+}
+
 func TestWithStderrCallback(t *testing.T) {
 	t.Run("sets stderr callback", func(t *testing.T) {
 		var received []string
@@ -732,3 +844,23 @@ func TestWithForkSession(t *testing.T) {
 		}
 	})
 }
+
+func TestWithStrictDecoding(t *testing.T) {
+	t.Run("enables strict decoding", func(t *testing.T) {
+		cfg := &config{}
+		applyOptions(cfg, WithStrictDecoding(true))
+
+		if !cfg.strictDecoding {
+			t.Error("strictDecoding should be true")
+		}
+	})
+
+	t.Run("is off by default", func(t *testing.T) {
+		cfg := &config{}
+		applyOptions(cfg)
+
+		if cfg.strictDecoding {
+			t.Error("strictDecoding should be false")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+//go:build !windows
+
+package claude
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubprocessTransport_Shutdown(t *testing.T) {
+	connect := func(t *testing.T, script string, grace time.Duration) *SubprocessTransport {
+		t.Helper()
+		st := NewSubprocessTransport(&config{
+			cliPath:             writeTestCLI(t, script),
+			shutdownGracePeriod: grace,
+		})
+		if err := st.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		return st
+	}
+
+	t.Run("returns nil when CLI exits after stdin closes", func(t *testing.T) {
+		st := connect(t, "cat >/dev/null\nexit 0\n", time.Minute)
+
+		if err := st.Close(); err != nil {
+			t.Errorf("Close() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("returns exit status when CLI exits non-zero", func(t *testing.T) {
+		st := connect(t, "cat >/dev/null\necho flushed >&2\nexit 4\n", time.Minute)
+
+		var procErr *ProcessError
+		err := st.Close()
+		if !errors.As(err, &procErr) {
+			t.Fatalf("Close() error = %v, want *ProcessError", err)
+		}
+		if procErr.ExitCode != 4 {
+			t.Errorf("ExitCode = %d, want 4", procErr.ExitCode)
+		}
+		if procErr.Stderr != "flushed" {
+			t.Errorf("Stderr = %q, want 'flushed'", procErr.Stderr)
+		}
+	})
+
+	t.Run("terminates the process group after the grace period", func(t *testing.T) {
+		// The background sleep holds stdout open, so Close only returns
+		// quickly if SIGTERM reaches the whole group.
+		st := connect(t, "sleep 30 &\nwait\n", 300*time.Millisecond)
+
+		start := time.Now()
+		var procErr *ProcessError
+		err := st.Close()
+		elapsed := time.Since(start)
+
+		if !errors.As(err, &procErr) || procErr.ExitCode != -1 {
+			t.Errorf("Close() error = %v, want *ProcessError for a signaled process", err)
+		}
+		if elapsed < 300*time.Millisecond || elapsed > 900*time.Millisecond {
+			t.Errorf("Close() took %v, want one grace period", elapsed)
+		}
+	})
+
+	t.Run("kills a CLI that ignores SIGTERM", func(t *testing.T) {
+		st := connect(t, "trap '' TERM\nwhile :; do sleep 0.05; done\n", 200*time.Millisecond)
+
+		start := time.Now()
+		var procErr *ProcessError
+		err := st.Close()
+		elapsed := time.Since(start)
+
+		if !errors.As(err, &procErr) || procErr.ExitCode != -1 {
+			t.Errorf("Close() error = %v, want *ProcessError for a killed process", err)
+		}
+		if elapsed < 400*time.Millisecond {
+			t.Errorf("Close() took %v, want at least two grace periods", elapsed)
+		}
+	})
+
+	t.Run("process outlives the Connect context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		st := NewSubprocessTransport(&config{cliPath: writeTestCLI(t, "read line\necho \"$line\"\ncat >/dev/null\n")})
+		if err := st.Connect(ctx); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+
+		if err := st.Send(context.Background(), []byte("{\"type\":\"result\"}\n")); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if msg := <-st.Messages(); string(msg) != `{"type":"result"}` {
+			t.Errorf("message = %q, want echoed line", msg)
+		}
+		if err := st.Close(); err != nil {
+			t.Errorf("Close() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("second Close returns nil", func(t *testing.T) {
+		st := connect(t, "cat >/dev/null\nexit 4\n", time.Minute)
+		_ = st.Close()
+
+		if err := st.Close(); err != nil {
+			t.Errorf("second Close() error = %v, want nil", err)
+		}
+	})
+}
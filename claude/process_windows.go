@@ -0,0 +1,23 @@
+//go:build windows
+
+package claude
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows.
+func setProcessGroup(_ *exec.Cmd) {}
+
+// terminateProcessGroup kills the CLI process. Windows has no SIGTERM,
+// so this is the same as killProcessGroup.
+func terminateProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// killProcessGroup kills the CLI process. Child processes are not
+// tracked on Windows.
+func killProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
@@ -3,6 +3,7 @@ package claude
 import (
 	"context"
 	"errors"
+	"iter"
 )
 
 // ErrNoResult is returned when a query completes without a result message.
@@ -14,6 +15,8 @@ var ErrNoResult = errors.New("claude: query completed without result message")
 //
 // The returned channel receives all messages until the query completes.
 // The channel is closed when the query completes or an error occurs.
+// If you stop reading early, cancel ctx to end the session; Stream
+// does this automatically when the loop exits.
 //
 // Example:
 //
@@ -33,50 +36,89 @@ var ErrNoResult = errors.New("claude: query completed without result message")
 //	    }
 //	}
 func Query(ctx context.Context, prompt string, opts ...Option) (<-chan Message, error) {
-	client := NewClient(opts...)
-
-	if err := client.Connect(ctx); err != nil {
-		return nil, err
-	}
-
-	if err := client.Query(ctx, prompt); err != nil {
-		_ = client.Close()
+	client, err := startQuery(ctx, prompt, opts...)
+	if err != nil {
 		return nil, err
 	}
 
-	// Create output channel
 	out := make(chan Message, 100)
 
-	// Start goroutine to read messages and clean up
 	go func() {
 		defer close(out)
 		defer func() { _ = client.Close() }()
 
-		msgs := client.Messages()
-		if msgs == nil {
-			return
-		}
-
-		for msg := range msgs {
+		client.receiveTurn(ctx, func(msg Message, err error) bool {
+			if err != nil {
+				return false
+			}
 			select {
 			case <-ctx.Done():
-				return
+				return false
 			case out <- msg:
-				// Close after sending ResultMessage - query is complete
-				if _, isResult := msg.(*ResultMessage); isResult {
-					return
-				}
+				return true
 			}
-		}
+		})
 	}()
 
 	return out, nil
 }
 
+// Stream sends a prompt to Claude and returns an iterator over the
+// response. It yields each message up to and including the ResultMessage.
+// If the session fails first, the last pair carries the error that ended
+// it (for example a *ProcessError or ctx.Err()), or ErrNoResult.
+//
+// The CLI is started when iteration begins and closed when it ends,
+// including when the loop exits early.
+//
+// Example:
+//
+//	for msg, err := range claude.Stream(ctx, "What is 2+2?") {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    if m, ok := msg.(*claude.AssistantMessage); ok {
+//	        fmt.Println(m.Content[0].Text)
+//	    }
+//	}
+func Stream(ctx context.Context, prompt string, opts ...Option) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		client, err := startQuery(ctx, prompt, opts...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer func() { _ = client.Close() }()
+
+		client.receiveTurn(ctx, yield)
+	}
+}
+
+// startQuery connects a new client and sends the prompt.
+func startQuery(ctx context.Context, prompt string, opts ...Option) (*Client, error) {
+	client := NewClient(opts...)
+
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := client.Query(ctx, prompt); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
 // QueryResult sends a prompt to Claude and returns the final ResultMessage.
 // This is a convenience function for simple queries where you only need
 // the final result, not intermediate messages.
 //
+// If the query ended with an error, the result is returned along with
+// ResultMessage.Err, for example ErrMaxTurns or an *APIError. If the
+// session ends without a result, the error that ended it is returned (for
+// example a *ProcessError), or ErrNoResult if there was none.
+//
 // Example:
 //
 //	result, err := claude.QueryResult(ctx, "What is 2+2?",
@@ -87,21 +129,13 @@ func Query(ctx context.Context, prompt string, opts ...Option) (<-chan Message,
 //	}
 //	fmt.Printf("Cost: $%.4f\n", result.TotalCostUSD)
 func QueryResult(ctx context.Context, prompt string, opts ...Option) (*ResultMessage, error) {
-	msgs, err := Query(ctx, prompt, opts...)
-	if err != nil {
-		return nil, err
-	}
-
-	var result *ResultMessage
-	for msg := range msgs {
-		if r, ok := msg.(*ResultMessage); ok {
-			result = r
+	for msg, err := range Stream(ctx, prompt, opts...) {
+		if err != nil {
+			return nil, err
+		}
+		if result, ok := msg.(*ResultMessage); ok {
+			return result, result.Err()
 		}
 	}
-
-	if result == nil {
-		return nil, ErrNoResult
-	}
-
-	return result, nil
+	return nil, ErrNoResult
 }
@@ -354,4 +354,154 @@ func TestQueryResult(t *testing.T) {
 			t.Error("QueryResult() error = nil, want error")
 		}
 	})
+	t.Run("returns process error instead of ErrNoResult", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.QueueError(&ProcessError{ExitCode: 2, Stderr: "invalid API key"})
+		mt.CloseMessages()
+
+		_, err := QueryResult(context.Background(), "test", WithTransport(mt))
+
+		var procErr *ProcessError
+		if !errors.As(err, &procErr) {
+			t.Fatalf("QueryResult() error = %v, want *ProcessError", err)
+		}
+		if procErr.ExitCode != 2 {
+			t.Errorf("ExitCode = %d, want 2", procErr.ExitCode)
+		}
+	})
+
+	t.Run("returns result and its error when the query failed", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt, `{"type":"result","subtype":"error_max_turns","is_error":true,"num_turns":5,"total_cost_usd":0.2}`)
+
+		result, err := QueryResult(context.Background(), "test", WithTransport(mt))
+
+		if !errors.Is(err, ErrMaxTurns) {
+			t.Errorf("QueryResult() error = %v, want %v", err, ErrMaxTurns)
+		}
+		if result == nil || result.TotalCostUSD != 0.2 {
+			t.Errorf("QueryResult() result = %+v, want the failed result", result)
+		}
+	})
+
+	t.Run("returns ErrNoResult when session ends cleanly", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.CloseMessages()
+
+		_, err := QueryResult(context.Background(), "test", WithTransport(mt))
+
+		if !errors.Is(err, ErrNoResult) {
+			t.Errorf("QueryResult() error = %v, want %v", err, ErrNoResult)
+		}
+	})
+}
+
+func TestStream(t *testing.T) {
+	t.Run("yields messages through the result", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"4"}],"model":"m"}}`,
+			testResultJSON,
+		)
+
+		var received []Message
+		for msg, err := range Stream(context.Background(), "What is 2+2?", WithTransport(mt)) {
+			if err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+			received = append(received, msg)
+		}
+
+		if len(received) != 2 {
+			t.Fatalf("received %d messages, want 2", len(received))
+		}
+		if _, ok := received[1].(*ResultMessage); !ok {
+			t.Errorf("last message = %T, want *ResultMessage", received[1])
+		}
+		if mt.IsReady() {
+			t.Error("transport should be closed")
+		}
+	})
+
+	t.Run("yields connection error", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.connectErr = ErrCLINotFound
+
+		var errs []error
+		for msg, err := range Stream(context.Background(), "test", WithTransport(mt)) {
+			if msg != nil {
+				t.Errorf("unexpected message %T", msg)
+			}
+			errs = append(errs, err)
+		}
+
+		if len(errs) != 1 || !errors.Is(errs[0], ErrCLINotFound) {
+			t.Errorf("errors = %v, want [%v]", errs, ErrCLINotFound)
+		}
+	})
+
+	t.Run("yields process error when the session ends early", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.QueueError(&ProcessError{ExitCode: 1})
+		mt.CloseMessages()
+
+		var last error
+		for _, err := range Stream(context.Background(), "test", WithTransport(mt)) {
+			last = err
+		}
+
+		var procErr *ProcessError
+		if !errors.As(last, &procErr) {
+			t.Errorf("last error = %v, want *ProcessError", last)
+		}
+	})
+
+	t.Run("closes the session when the loop breaks", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"one"}],"model":"m"}}`,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"two"}],"model":"m"}}`,
+		)
+
+		count := 0
+		for range Stream(context.Background(), "test", WithTransport(mt)) {
+			count++
+			break
+		}
+
+		if count != 1 {
+			t.Errorf("iterations = %d, want 1", count)
+		}
+		if mt.IsReady() {
+			t.Error("transport should be closed after break")
+		}
+	})
+
+	t.Run("yields context error", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			mt := newMockTransport()
+			ctx, cancel := context.WithCancel(context.Background())
+
+			done := make(chan error, 1)
+			go func() {
+				for _, err := range Stream(ctx, "test", WithTransport(mt)) {
+					done <- err
+				}
+			}()
+			synctest.Wait()
+			cancel()
+
+			if err := <-done; !errors.Is(err, context.Canceled) {
+				t.Errorf("error = %v, want %v", err, context.Canceled)
+			}
+			synctest.Wait()
+			if mt.IsReady() {
+				t.Error("transport should be closed")
+			}
+
+			// Close the transport channel to allow the Client's readMessages goroutine to exit
+			close(mt.messagesCh)
+			synctest.Wait()
+		})
+	})
 }
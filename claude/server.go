@@ -0,0 +1,122 @@
+package claude
+
+import "slices"
+
+// ServerInfo describes the CLI session established by Connect.
+//
+// Commands, output styles and models come from the CLI's initialize
+// response and are available as soon as Connect returns. The remaining
+// fields come from the CLI's init system message and are filled in when
+// that message arrives, which is typically with the first response.
+type ServerInfo struct {
+	// Commands lists the available slash commands.
+	Commands []SlashCommand
+
+	// OutputStyle is the active output style.
+	OutputStyle string
+
+	// OutputStyles lists the available output styles.
+	OutputStyles []string
+
+	// Models lists the models the CLI can use.
+	Models []ModelInfo
+
+	// SessionID is the CLI session ID.
+	SessionID string
+
+	// Tools lists the tools available to Claude.
+	Tools []string
+
+	// MCPServers reports the connection status of each MCP server.
+	MCPServers []MCPServerStatus
+
+	// Model is the model in use.
+	Model string
+
+	// Cwd is the CLI's working directory.
+	Cwd string
+
+	// CLIVersion is the version of the Claude CLI.
+	CLIVersion string
+
+	// PermissionMode is the active permission mode.
+	PermissionMode PermissionMode
+
+	// APIKeySource describes where the CLI found its API key.
+	APIKeySource string
+}
+
+// SlashCommand describes a slash command available in the session.
+type SlashCommand struct {
+	Name         string
+	Description  string
+	ArgumentHint string
+}
+
+// ModelInfo describes a model the CLI can use.
+type ModelInfo struct {
+	Value       string
+	DisplayName string
+	Description string
+}
+
+// MCPServerStatus reports the connection status of an MCP server,
+// such as "connected" or "failed".
+type MCPServerStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// applyInitialize fills in the fields reported by the initialize
+// response. Its commands replace any names taken from the init system
+// message, since they are described in more detail.
+func (s *ServerInfo) applyInitialize(resp map[string]any) {
+	if style := getString(resp, "output_style"); style != "" {
+		s.OutputStyle = style
+	}
+	s.OutputStyles = getStrings(resp, "available_output_styles")
+
+	s.Commands = nil
+	for _, item := range getSlice(resp, "commands") {
+		cmd, _ := item.(map[string]any)
+		s.Commands = append(s.Commands, SlashCommand{
+			Name:         getString(cmd, "name"),
+			Description:  getString(cmd, "description"),
+			ArgumentHint: getString(cmd, "argumentHint"),
+		})
+	}
+
+	s.Models = nil
+	for _, item := range getSlice(resp, "models") {
+		model, _ := item.(map[string]any)
+		s.Models = append(s.Models, ModelInfo{
+			Value:       getString(model, "value"),
+			DisplayName: getString(model, "displayName"),
+			Description: getString(model, "description"),
+		})
+	}
+}
+
+// applyInit fills in the fields reported by the init system message.
+func (s *ServerInfo) applyInit(msg *SystemInitMessage) {
+	s.SessionID = msg.SessionID
+	s.Tools = slices.Clone(msg.Tools)
+	s.MCPServers = slices.Clone(msg.MCPServers)
+	s.Model = msg.Model
+	s.Cwd = msg.Cwd
+	s.CLIVersion = msg.CLIVersion
+	s.PermissionMode = msg.PermissionMode
+	s.APIKeySource = msg.APIKeySource
+
+	if msg.OutputStyle != "" {
+		s.OutputStyle = msg.OutputStyle
+	}
+
+	// The initialize response describes commands in more detail, so the
+	// init message's command names are only used when it had none.
+	if len(s.Commands) == 0 {
+		for _, name := range msg.SlashCommands {
+			s.Commands = append(s.Commands, SlashCommand{Name: name})
+		}
+	}
+}
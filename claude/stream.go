@@ -0,0 +1,351 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// StreamEventType identifies an Anthropic API streaming event.
+type StreamEventType string
+
+// Stream event types sent when partial messages are enabled.
+const (
+	StreamMessageStart      StreamEventType = "message_start"
+	StreamContentBlockStart StreamEventType = "content_block_start"
+	StreamContentBlockDelta StreamEventType = "content_block_delta"
+	StreamContentBlockStop  StreamEventType = "content_block_stop"
+	StreamMessageDelta      StreamEventType = "message_delta"
+	StreamMessageStop       StreamEventType = "message_stop"
+)
+
+// DeltaType identifies the kind of change in a content_block_delta event.
+type DeltaType string
+
+// Content block delta types.
+const (
+	DeltaText      DeltaType = "text_delta"
+	DeltaThinking  DeltaType = "thinking_delta"
+	DeltaInputJSON DeltaType = "input_json_delta"
+	DeltaSignature DeltaType = "signature_delta"
+)
+
+// StreamDelta is an incremental change to a content block.
+type StreamDelta struct {
+	// Type identifies which field carries the change.
+	Type DeltaType
+
+	// Text is appended to a text block.
+	Text string
+
+	// Thinking is appended to a thinking block.
+	Thinking string
+
+	// PartialJSON is appended to a tool use block's input JSON.
+	PartialJSON string
+
+	// Signature is the signature of a thinking block.
+	Signature string
+}
+
+// MessageAccumulator rebuilds in-progress assistant messages from stream
+// events, so a UI can render the response as it is generated.
+//
+// Events from subagents are tracked separately by ParentToolUseID. A zero
+// MessageAccumulator is ready to use. It is not safe for concurrent use.
+//
+//	var acc claude.MessageAccumulator
+//	for msg := range client.Messages() {
+//	    if event, ok := msg.(*claude.StreamEvent); ok {
+//	        render(acc.Add(event))
+//	    }
+//	}
+type MessageAccumulator struct {
+	messages map[string]*partialMessage
+}
+
+// partialMessage is the state of one message being accumulated.
+type partialMessage struct {
+	msg   *AssistantMessage
+	input map[int]*partialInput
+}
+
+// partialInput is the input JSON of a tool use block that is streaming in.
+type partialInput struct {
+	json strings.Builder
+
+	// parsed is the length of json when it was last parsed.
+	parsed int
+}
+
+// partialInputParseAll is the input size up to which the input is parsed
+// again on every delta. Each parse reads the whole input, so beyond it
+// the input is parsed only once it has grown by an eighth, which keeps
+// the total work linear in the input size.
+const partialInputParseAll = 4 << 10
+
+// Add applies a stream event and returns a snapshot of the message it
+// belongs to. It returns nil if no message has started for the event's
+// ParentToolUseID.
+//
+// Tool use input is parsed as it streams in: incomplete JSON is closed
+// off and the fields decoded so far are returned in ToolInput.
+func (a *MessageAccumulator) Add(event *StreamEvent) *AssistantMessage {
+	if a.messages == nil {
+		a.messages = make(map[string]*partialMessage)
+	}
+
+	if event.Type == StreamMessageStart {
+		p := &partialMessage{
+			msg:   &AssistantMessage{},
+			input: make(map[int]*partialInput),
+		}
+		if event.Message != nil {
+			*p.msg = *event.Message
+			p.msg.Content = nil
+			for i, block := range event.Message.Content {
+				b := *block
+				p.setBlock(i, &b)
+			}
+		}
+		p.msg.ParentToolUseID = event.ParentToolUseID
+		a.messages[event.ParentToolUseID] = p
+	}
+
+	p := a.messages[event.ParentToolUseID]
+	if p == nil {
+		return nil
+	}
+	p.apply(event)
+	return p.snapshot()
+}
+
+// Snapshot returns the current state of the message for parentToolUseID,
+// or nil if none has started. Use "" for the main conversation.
+func (a *MessageAccumulator) Snapshot(parentToolUseID string) *AssistantMessage {
+	if p := a.messages[parentToolUseID]; p != nil {
+		return p.snapshot()
+	}
+	return nil
+}
+
+// apply updates the message with one event.
+func (p *partialMessage) apply(event *StreamEvent) {
+	switch event.Type {
+	case StreamContentBlockStart:
+		if event.ContentBlock != nil {
+			block := *event.ContentBlock
+			p.setBlock(event.Index, &block)
+		}
+
+	case StreamContentBlockDelta:
+		if event.Delta != nil {
+			p.applyDelta(event.Index, event.Delta)
+		}
+
+	case StreamContentBlockStop:
+		p.finishInput(event.Index)
+
+	case StreamMessageDelta:
+		if event.StopReason != "" {
+			p.msg.StopReason = event.StopReason
+		}
+		if event.Usage != nil {
+			mergeUsage(&p.msg.Usage, event.Usage)
+		}
+
+	case StreamMessageStart, StreamMessageStop:
+		// message_start is handled by Add; message_stop changes nothing.
+	}
+}
+
+// applyDelta applies a content block delta to the block at index.
+func (p *partialMessage) applyDelta(index int, delta *StreamDelta) {
+	block := p.block(index)
+	if block == nil {
+		return
+	}
+
+	switch delta.Type {
+	case DeltaText:
+		block.Text += delta.Text
+	case DeltaThinking:
+		block.Thinking += delta.Thinking
+	case DeltaSignature:
+		block.Signature = delta.Signature
+	case DeltaInputJSON:
+		in := p.input[index]
+		if in == nil {
+			in = &partialInput{}
+			p.input[index] = in
+		}
+		in.json.WriteString(delta.PartialJSON)
+		if n := in.json.Len(); n <= partialInputParseAll || n-in.parsed >= in.parsed/8 {
+			in.parsed = n
+			if input := parsePartialJSON(in.json.String()); input != nil {
+				block.ToolInput = input
+			}
+		}
+	}
+}
+
+// finishInput decodes the complete input JSON of the block at index.
+func (p *partialMessage) finishInput(index int) {
+	in := p.input[index]
+	block := p.block(index)
+	if in == nil || block == nil {
+		return
+	}
+	delete(p.input, index)
+
+	var input map[string]any
+	if err := json.Unmarshal([]byte(in.json.String()), &input); err == nil {
+		block.ToolInput = input
+	}
+}
+
+// block returns the block at index, or nil if it has not started.
+func (p *partialMessage) block(index int) *ContentBlock {
+	if index < 0 || index >= len(p.msg.Content) {
+		return nil
+	}
+	return p.msg.Content[index]
+}
+
+// setBlock stores a block at index, growing the content as needed.
+func (p *partialMessage) setBlock(index int, block *ContentBlock) {
+	if index < 0 {
+		return
+	}
+	for len(p.msg.Content) <= index {
+		p.msg.Content = append(p.msg.Content, nil)
+	}
+	p.msg.Content[index] = block
+}
+
+// snapshot returns a copy of the message that later events do not modify.
+func (p *partialMessage) snapshot() *AssistantMessage {
+	msg := *p.msg
+	msg.Content = make([]*ContentBlock, 0, len(p.msg.Content))
+	for _, block := range p.msg.Content {
+		if block != nil {
+			b := *block
+			msg.Content = append(msg.Content, &b)
+		}
+	}
+	return &msg
+}
+
+// mergeUsage copies the counts reported in a message_delta event. The
+// API reports cumulative totals, so non-zero values replace earlier ones.
+func mergeUsage(dst, src *Usage) {
+	if src.InputTokens != 0 {
+		dst.InputTokens = src.InputTokens
+	}
+	if src.OutputTokens != 0 {
+		dst.OutputTokens = src.OutputTokens
+	}
+	if src.CacheCreationInputTokens != 0 {
+		dst.CacheCreationInputTokens = src.CacheCreationInputTokens
+	}
+	if src.CacheReadInputTokens != 0 {
+		dst.CacheReadInputTokens = src.CacheReadInputTokens
+	}
+	if src.ServerToolUse.WebSearchRequests != 0 {
+		dst.ServerToolUse.WebSearchRequests = src.ServerToolUse.WebSearchRequests
+	}
+	if src.ServerToolUse.WebFetchRequests != 0 {
+		dst.ServerToolUse.WebFetchRequests = src.ServerToolUse.WebFetchRequests
+	}
+}
+
+// parsePartialJSON decodes a JSON object that may be cut off part way
+// through. It returns the fields that are complete so far, plus a
+// truncated final string value, or nil if nothing can be decoded.
+func parsePartialJSON(s string) map[string]any {
+	completed, fallback := completeJSON(s)
+	for _, candidate := range []string{completed, fallback} {
+		var out map[string]any
+		if err := json.Unmarshal([]byte(candidate), &out); err == nil {
+			return out
+		}
+	}
+	return nil
+}
+
+// completeJSON closes off truncated JSON. The first result keeps as much
+// as possible, closing an open string. The second cuts back to the last
+// complete value, for input that ends part way through a key, literal or
+// escape sequence.
+func completeJSON(s string) (completed, fallback string) {
+	var (
+		closers           []byte
+		inString, escaped bool
+		safeEnd           int
+		safeClosers       string
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			if c == '{' {
+				closers = append(closers, '}')
+			} else {
+				closers = append(closers, ']')
+			}
+			safeEnd, safeClosers = i+1, string(closers)
+		case '}', ']':
+			if len(closers) > 0 {
+				closers = closers[:len(closers)-1]
+			}
+			safeEnd, safeClosers = i+1, string(closers)
+		case ',':
+			safeEnd, safeClosers = i, string(closers)
+		}
+	}
+
+	var b strings.Builder
+	if inString {
+		if escaped {
+			s = s[:len(s)-1]
+		}
+		b.WriteString(s)
+		b.WriteByte('"')
+	} else {
+		tail := strings.TrimRight(s, " \t\r\n")
+		switch {
+		case strings.HasSuffix(tail, ","):
+			b.WriteString(tail[:len(tail)-1])
+		case strings.HasSuffix(tail, ":"):
+			b.WriteString(tail)
+			b.WriteString("null")
+		default:
+			b.WriteString(tail)
+		}
+	}
+	for i := len(closers) - 1; i >= 0; i-- {
+		b.WriteByte(closers[i])
+	}
+
+	var f strings.Builder
+	f.WriteString(s[:safeEnd])
+	for i := len(safeClosers) - 1; i >= 0; i-- {
+		f.WriteByte(safeClosers[i])
+	}
+
+	return b.String(), f.String()
+}
@@ -0,0 +1,261 @@
+package claude
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// streamEvent decodes a raw API stream event as the client would.
+func streamEvent(t *testing.T, parentToolUseID, event string) *StreamEvent {
+	t.Helper()
+	if !json.Valid([]byte(event)) {
+		t.Fatalf("invalid event JSON: %s", event)
+	}
+	return parseStreamEvent(&messageEnvelope{
+		Type:            "stream_event",
+		ParentToolUseID: parentToolUseID,
+		Event:           rawJSON(event),
+	})
+}
+
+func TestDecodeStreamEvent(t *testing.T) {
+	t.Run("message_start", func(t *testing.T) {
+		event := streamEvent(t, "", `{"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4-5","content":[],"usage":{"input_tokens":12}}}`)
+
+		if event.Type != StreamMessageStart {
+			t.Fatalf("Type = %q, want message_start", event.Type)
+		}
+		if event.Message == nil || event.Message.ID != "msg_1" || event.Message.Usage.InputTokens != 12 {
+			t.Errorf("Message = %+v, want msg_1 with 12 input tokens", event.Message)
+		}
+	})
+
+	t.Run("content_block_start", func(t *testing.T) {
+		event := streamEvent(t, "", `{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"tu_1","name":"Read","input":{}}}`)
+
+		if event.Type != StreamContentBlockStart || event.Index != 1 {
+			t.Fatalf("Type = %q, Index = %d, want content_block_start at 1", event.Type, event.Index)
+		}
+		if event.ContentBlock == nil || !event.ContentBlock.IsToolUse() || event.ContentBlock.ToolName != "Read" {
+			t.Errorf("ContentBlock = %+v, want Read tool use", event.ContentBlock)
+		}
+	})
+
+	t.Run("input_json_delta", func(t *testing.T) {
+		event := streamEvent(t, "", `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}`)
+
+		if event.Delta == nil || event.Delta.Type != DeltaInputJSON || event.Delta.PartialJSON != `{"path":` {
+			t.Errorf("Delta = %+v, want input_json_delta", event.Delta)
+		}
+	})
+
+	t.Run("message_delta", func(t *testing.T) {
+		event := streamEvent(t, "", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":42}}`)
+
+		if event.Type != StreamMessageDelta || event.StopReason != "end_turn" {
+			t.Errorf("Type = %q, StopReason = %q, want message_delta end_turn", event.Type, event.StopReason)
+		}
+		if event.Usage == nil || event.Usage.OutputTokens != 42 {
+			t.Errorf("Usage = %+v, want 42 output tokens", event.Usage)
+		}
+	})
+
+	t.Run("message_stop", func(t *testing.T) {
+		event := streamEvent(t, "", `{"type":"message_stop"}`)
+
+		if event.Type != StreamMessageStop {
+			t.Errorf("Type = %q, want message_stop", event.Type)
+		}
+	})
+}
+
+func TestMessageAccumulator(t *testing.T) {
+	t.Run("rebuilds text, thinking and tool use", func(t *testing.T) {
+		var acc MessageAccumulator
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4-5","content":[],"usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"look."}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Reading "}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"the file."}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"tu_1","name":"Read","input":{}}}`,
+			`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"file_path\": \"/tmp/a"}}`,
+			`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":".go\", \"limit\": 10}"}}`,
+			`{"type":"content_block_stop","index":2}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":25}}`,
+			`{"type":"message_stop"}`,
+		}
+
+		var msg *AssistantMessage
+		for _, e := range events {
+			msg = acc.Add(streamEvent(t, "", e))
+		}
+
+		if msg.ID != "msg_1" || msg.Model != "claude-sonnet-4-5" || msg.StopReason != "tool_use" {
+			t.Errorf("msg = %+v, want msg_1 stopped for tool_use", msg)
+		}
+		if msg.Usage.InputTokens != 10 || msg.Usage.OutputTokens != 25 {
+			t.Errorf("Usage = %+v, want 10 input and 25 output tokens", msg.Usage)
+		}
+		if len(msg.Content) != 3 {
+			t.Fatalf("Content length = %d, want 3", len(msg.Content))
+		}
+		if msg.Content[0].Thinking != "Let me look." || msg.Content[0].Signature != "sig" {
+			t.Errorf("Content[0] = %+v, want signed thinking", msg.Content[0])
+		}
+		if msg.Content[1].Text != "Reading the file." {
+			t.Errorf("Content[1].Text = %q, want 'Reading the file.'", msg.Content[1].Text)
+		}
+		want := map[string]any{"file_path": "/tmp/a.go", "limit": float64(10)}
+		if !reflect.DeepEqual(msg.Content[2].ToolInput, want) {
+			t.Errorf("Content[2].ToolInput = %v, want %v", msg.Content[2].ToolInput, want)
+		}
+	})
+
+	t.Run("parses partial tool input while streaming", func(t *testing.T) {
+		var acc MessageAccumulator
+		acc.Add(streamEvent(t, "", `{"type":"message_start","message":{"id":"msg_1","content":[]}}`))
+		acc.Add(streamEvent(t, "", `{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"tu_1","name":"Write","input":{}}}`))
+
+		msg := acc.Add(streamEvent(t, "", `{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"file_path\": \"a.go\", \"content\": \"package ma"}}`))
+
+		want := map[string]any{"file_path": "a.go", "content": "package ma"}
+		if !reflect.DeepEqual(msg.Content[0].ToolInput, want) {
+			t.Errorf("ToolInput = %v, want %v", msg.Content[0].ToolInput, want)
+		}
+	})
+
+	t.Run("parses large tool input in linear time", func(t *testing.T) {
+		var acc MessageAccumulator
+		acc.Add(streamEvent(t, "", `{"type":"message_start","message":{"id":"msg_1","content":[]}}`))
+		acc.Add(streamEvent(t, "", `{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"tu_1","name":"Write","input":{}}}`))
+
+		content := strings.Repeat("0123456789", 10000)
+		deltas := inputDeltas(`{"content": "`+content+`"}`, 100)
+		updates, last := 0, ""
+		for _, delta := range deltas {
+			msg := acc.Add(delta)
+			if got, _ := msg.Content[0].ToolInput["content"].(string); got != last {
+				updates, last = updates+1, got
+			}
+		}
+		msg := acc.Add(streamEvent(t, "", `{"type":"content_block_stop","index":0}`))
+
+		if updates < 10 || updates > len(deltas)/10 {
+			t.Errorf("input parsed %d times for %d deltas, want a few dozen", updates, len(deltas))
+		}
+		if msg.Content[0].ToolInput["content"] != content {
+			t.Error("ToolInput is incomplete after content_block_stop")
+		}
+	})
+
+	t.Run("snapshots are not modified by later events", func(t *testing.T) {
+		var acc MessageAccumulator
+		acc.Add(streamEvent(t, "", `{"type":"message_start","message":{"id":"msg_1","content":[]}}`))
+		acc.Add(streamEvent(t, "", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`))
+		first := acc.Add(streamEvent(t, "", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`))
+
+		acc.Add(streamEvent(t, "", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`))
+
+		if first.Content[0].Text != "Hel" {
+			t.Errorf("first snapshot Text = %q, want 'Hel'", first.Content[0].Text)
+		}
+		if got := acc.Snapshot("").Content[0].Text; got != "Hello" {
+			t.Errorf("Snapshot Text = %q, want 'Hello'", got)
+		}
+	})
+
+	t.Run("tracks subagent messages separately", func(t *testing.T) {
+		var acc MessageAccumulator
+		acc.Add(streamEvent(t, "", `{"type":"message_start","message":{"id":"main","content":[]}}`))
+		acc.Add(streamEvent(t, "", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":"main"}}`))
+		acc.Add(streamEvent(t, "task-1", `{"type":"message_start","message":{"id":"sub","content":[]}}`))
+		sub := acc.Add(streamEvent(t, "task-1", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":"sub"}}`))
+
+		if sub.ID != "sub" || sub.ParentToolUseID != "task-1" || sub.Content[0].Text != "sub" {
+			t.Errorf("subagent snapshot = %+v, want sub message", sub)
+		}
+		if main := acc.Snapshot(""); main.ID != "main" || main.Content[0].Text != "main" {
+			t.Errorf("main snapshot = %+v, want main message", main)
+		}
+	})
+
+	t.Run("returns nil before message_start", func(t *testing.T) {
+		var acc MessageAccumulator
+
+		msg := acc.Add(streamEvent(t, "", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"x"}}`))
+
+		if msg != nil {
+			t.Errorf("Add() = %+v, want nil", msg)
+		}
+		if acc.Snapshot("") != nil {
+			t.Error("Snapshot() should be nil")
+		}
+	})
+}
+
+// inputDeltas splits input JSON into input_json_delta events of size
+// bytes for the block at index 0.
+func inputDeltas(input string, size int) []*StreamEvent {
+	var events []*StreamEvent
+	for len(input) > 0 {
+		n := min(size, len(input))
+		events = append(events, &StreamEvent{
+			Type:  StreamContentBlockDelta,
+			Delta: &StreamDelta{Type: DeltaInputJSON, PartialJSON: input[:n]},
+		})
+		input = input[n:]
+	}
+	return events
+}
+
+func BenchmarkMessageAccumulatorToolInput(b *testing.B) {
+	deltas := inputDeltas(`{"file_path": "a.go", "content": "`+strings.Repeat("line of code\n", 20000)+`"}`, 50)
+	start := &StreamEvent{Type: StreamMessageStart}
+	block := &StreamEvent{Type: StreamContentBlockStart, ContentBlock: &ContentBlock{Kind: BlockToolUse}}
+	b.ReportAllocs()
+	for b.Loop() {
+		var acc MessageAccumulator
+		acc.Add(start)
+		acc.Add(block)
+		for _, delta := range deltas {
+			acc.Add(delta)
+		}
+	}
+}
+
+func TestParsePartialJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]any
+	}{
+		{"complete object", `{"a": 1}`, map[string]any{"a": float64(1)}},
+		{"open string value", `{"a": "hel`, map[string]any{"a": "hel"}},
+		{"open nested array", `{"a": [1, 2`, map[string]any{"a": []any{float64(1), float64(2)}}},
+		{"trailing comma", `{"a": 1, `, map[string]any{"a": float64(1)}},
+		{"missing value", `{"a": `, map[string]any{"a": nil}},
+		{"partial key", `{"a": 1, "b`, map[string]any{"a": float64(1)}},
+		{"partial literal", `{"a": 1, "b": tru`, map[string]any{"a": float64(1)}},
+		{"trailing escape", `{"a": "x\`, map[string]any{"a": "x"}},
+		{"only brace", `{`, map[string]any{}},
+		{"empty", ``, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePartialJSON(tt.input)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePartialJSON(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
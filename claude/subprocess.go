@@ -2,8 +2,10 @@ package claude
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,11 +15,16 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // osWindows is the GOOS value for Windows.
 const osWindows = "windows"
 
+// stderrTailLines is the number of recent stderr lines kept for ProcessError.
+const stderrTailLines = 100
+
 // SubprocessTransport implements Transport using the Claude CLI subprocess.
 type SubprocessTransport struct {
 	cliPath  string
@@ -30,14 +37,30 @@ type SubprocessTransport struct {
 	errors   chan error
 	ready    bool
 	mu       sync.RWMutex
+
+	// stderrTail keeps recent stderr lines; stderrDone is closed once
+	// stderr has been fully read.
+	stderrTail *lineRing
+	stderrDone chan struct{}
+
+	// closed is closed by Close to release a reader blocked on delivery.
+	closed  chan struct{}
+	dropped atomic.Uint64
+
+	// exited is closed once the process has been waited for; waitErr
+	// holds the result of cmd.Wait.
+	exited  chan struct{}
+	waitErr error
 }
 
 // NewSubprocessTransport creates a new subprocess transport.
 func NewSubprocessTransport(cfg *config) *SubprocessTransport {
 	st := &SubprocessTransport{
-		cfg:      cfg,
-		messages: make(chan []byte, 100),
-		errors:   make(chan error, 10),
+		cfg:        cfg,
+		messages:   make(chan []byte, cfg.messageBuffer()),
+		errors:     make(chan error, 10),
+		stderrTail: newLineRing(stderrTailLines),
+		closed:     make(chan struct{}),
 	}
 
 	// Use custom CLI path if provided
@@ -134,6 +157,10 @@ func (st *SubprocessTransport) addToolOptions(cmd []string, cfg *config) []strin
 	if len(cfg.disallowedTools) > 0 {
 		cmd = append(cmd, "--disallowedTools", strings.Join(cfg.disallowedTools, ","))
 	}
+	// Route permission prompts to the SDK over the control protocol
+	if cfg.canUseTool != nil {
+		cmd = append(cmd, "--permission-prompt-tool", "stdio")
+	}
 	return cmd
 }
 
@@ -148,11 +175,33 @@ func (st *SubprocessTransport) addSessionOptions(cmd []string, cfg *config) []st
 	if cfg.maxThinkingTokens > 0 {
 		cmd = append(cmd, "--max-thinking-tokens", strconv.Itoa(cfg.maxThinkingTokens))
 	}
+	cmd = st.addMCPOptions(cmd, cfg)
+	if cfg.forkSession {
+		cmd = append(cmd, "--fork-session")
+	}
+	return cmd
+}
+
+// addMCPOptions adds MCP config files and in-process SDK servers.
+func (st *SubprocessTransport) addMCPOptions(cmd []string, cfg *config) []string {
 	if cfg.mcpConfig != "" {
 		cmd = append(cmd, "--mcp-config", cfg.mcpConfig)
 	}
-	if cfg.forkSession {
-		cmd = append(cmd, "--fork-session")
+
+	// SDK servers are advertised by name only; the CLI routes their
+	// JSON-RPC traffic back to us as mcp_message control requests.
+	if len(cfg.mcpServers) > 0 {
+		servers := make(map[string]any, len(cfg.mcpServers))
+		for name := range cfg.mcpServers {
+			servers[name] = map[string]any{
+				"type": "sdk",
+				"name": name,
+			}
+		}
+		mcpJSON, err := json.Marshal(map[string]any{"mcpServers": servers})
+		if err == nil {
+			cmd = append(cmd, "--mcp-config", string(mcpJSON))
+		}
 	}
 	return cmd
 }
@@ -278,8 +327,14 @@ func (st *SubprocessTransport) addSandboxNetworkOptions(cmd []string, sandbox *S
 	return cmd
 }
 
-// Connect starts the subprocess.
+// Connect starts the subprocess. The context only bounds starting the
+// process; once started, the process runs until Close is called or it
+// exits on its own.
 func (st *SubprocessTransport) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	st.mu.Lock()
 	defer st.mu.Unlock()
 
@@ -298,7 +353,9 @@ func (st *SubprocessTransport) Connect(ctx context.Context) error {
 
 	// Build command
 	args := st.buildCommand()
-	st.cmd = exec.CommandContext(ctx, args[0], args[1:]...) //nolint:gosec // args are from trusted config
+	st.cmd = exec.Command(args[0], args[1:]...) //nolint:gosec // args are from trusted config
+
+	setProcessGroup(st.cmd)
 
 	// Set working directory if specified
 	if st.cfg.workingDir != "" {
@@ -346,58 +403,60 @@ func (st *SubprocessTransport) Connect(ctx context.Context) error {
 	st.stdout = stdoutPipe
 	st.stderr = stderrPipe
 
+	// Start reading stderr first so readMessages can wait for it to drain
+	st.stderrDone = make(chan struct{})
+	go st.readStderr()
+
 	// Start reading messages
+	st.exited = make(chan struct{})
 	go st.readMessages(stdoutPipe)
 
-	// Start stderr reader if callback is configured
-	if st.cfg.stderrCallback != nil {
-		go st.readStderr()
-	}
-
 	st.ready = true
 	return nil
 }
 
 // readMessages reads from stdout and sends to messages channel.
+// After Close, output is still read but discarded so the CLI can
+// flush and exit.
 func (st *SubprocessTransport) readMessages(stdout interface{ Read([]byte) (int, error) }) {
 	defer close(st.messages)
 
-	scanner := bufio.NewScanner(stdout)
-	// Set a larger buffer for potentially large JSON messages
-	maxScanTokenSize := 1024 * 1024 // 1MB default
-	if st.cfg.maxBufferSize > 0 {
-		maxScanTokenSize = st.cfg.maxBufferSize
-	}
-	buf := make([]byte, maxScanTokenSize)
-	scanner.Buffer(buf, maxScanTokenSize)
+	reader := newLineReader(stdout, st.cfg.maxBufferSize)
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
+	delivering := true
+	for {
+		line, err := reader.readLine()
+		var tooLarge *MessageTooLargeError
+		if errors.As(err, &tooLarge) {
+			if delivering && st.cfg.messageErrorCallback != nil {
+				st.cfg.messageErrorCallback(tooLarge)
+			}
 			continue
 		}
-
-		// Copy the line data since scanner reuses the buffer
-		data := make([]byte, len(line))
-		copy(data, line)
-
-		select {
-		case st.messages <- data:
-		default:
-			// Channel full, drop message
+		if len(line) > 0 && delivering {
+			delivering = st.deliver(line)
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		select {
-		case st.errors <- err:
-		default:
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
+				select {
+				case st.errors <- err:
+				default:
+				}
+			}
+			break
 		}
 	}
 
-	// Wait for process to exit
+	// Wait for process to exit. Stderr must be fully read before Wait.
 	if st.cmd != nil {
-		if err := st.cmd.Wait(); err != nil {
+		if st.stderrDone != nil {
+			<-st.stderrDone
+		}
+		st.waitErr = st.cmd.Wait()
+		if st.exited != nil {
+			close(st.exited)
+		}
+		if err := st.exitError(st.waitErr); err != nil {
 			select {
 			case st.errors <- err:
 			default:
@@ -408,14 +467,160 @@ func (st *SubprocessTransport) readMessages(stdout interface{ Read([]byte) (int,
 	close(st.errors)
 }
 
-// readStderr reads stderr line by line and calls the callback.
+// lineReader splits output into lines. Lines are returned in slices of
+// their own that stay valid after the next read, so a message that keeps
+// its line keeps no other output alive.
+type lineReader struct {
+	r     *bufio.Reader
+	limit int
+}
+
+func newLineReader(r io.Reader, limit int) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, 64*1024), limit: limit}
+}
+
+// readLine returns the next line without its line ending. If limit is
+// positive and the line is longer, the rest of it is read and discarded,
+// and readLine returns the first limit bytes with a *MessageTooLargeError.
+// At the end of the input it returns the last line, if any, with io.EOF.
+func (lr *lineReader) readLine() ([]byte, error) {
+	chunk, err := lr.r.ReadSlice('\n')
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		line := trimLineEnding(chunk)
+		if lr.limit > 0 && len(line) > lr.limit {
+			return bytes.Clone(line[:lr.limit]), &MessageTooLargeError{Size: len(line), Limit: lr.limit}
+		}
+		return bytes.Clone(line), err
+	}
+
+	line := bytes.Clone(chunk)
+	size := len(chunk)
+	for errors.Is(err, bufio.ErrBufferFull) {
+		chunk, err = lr.r.ReadSlice('\n')
+		size += len(chunk)
+		// Two extra bytes leave room for a "\r\n" line ending.
+		if room := lr.limit + 2 - len(line); lr.limit <= 0 || room >= len(chunk) {
+			line = append(line, chunk...)
+		} else if room > 0 {
+			line = append(line, chunk[:room]...)
+		}
+	}
+
+	if lr.limit > 0 && size > lr.limit+2 {
+		size -= len(chunk) - len(bytes.TrimRight(chunk, "\r\n"))
+		return line[:lr.limit], &MessageTooLargeError{Size: size, Limit: lr.limit}
+	}
+	line = trimLineEnding(line)
+	if lr.limit > 0 && len(line) > lr.limit {
+		return line[:lr.limit], &MessageTooLargeError{Size: len(line), Limit: lr.limit}
+	}
+	return line, err
+}
+
+// trimLineEnding removes a trailing "\n" or "\r\n" from line.
+func trimLineEnding(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
+
+// deliver sends a line to the messages channel. It blocks while the
+// channel is full, except for stream events under OverflowDropStreamEvents,
+// which are counted and dropped. Returns false if the transport was closed.
+func (st *SubprocessTransport) deliver(data []byte) bool {
+	if st.cfg.overflowPolicy == OverflowDropStreamEvents {
+		select {
+		case st.messages <- data:
+			return true
+		default:
+		}
+		if isStreamEvent(data) {
+			st.dropped.Add(1)
+			return true
+		}
+	}
+
+	select {
+	case st.messages <- data:
+		return true
+	case <-st.closed:
+		return false
+	}
+}
+
+// isStreamEvent reports whether a line is a partial stream_event message.
+func isStreamEvent(data []byte) bool {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(data, &envelope) == nil && envelope.Type == "stream_event"
+}
+
+// DroppedMessages returns the number of stream events dropped because the
+// message buffer was full under OverflowDropStreamEvents.
+func (st *SubprocessTransport) DroppedMessages() uint64 {
+	return st.dropped.Load()
+}
+
+// exitError converts the result of cmd.Wait into the error reported on
+// Errors(). Exits caused by Close are not reported; Close returns them.
+func (st *SubprocessTransport) exitError(err error) error {
+	st.mu.RLock()
+	closed := !st.ready
+	st.mu.RUnlock()
+	if closed {
+		return nil
+	}
+	return st.processError(err)
+}
+
+// processError converts the result of cmd.Wait into an error. A non-zero
+// exit becomes a *ProcessError carrying the tail of stderr.
+func (st *SubprocessTransport) processError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ProcessError{
+			ExitCode: exitErr.ExitCode(),
+			Stderr:   st.stderrTail.String(),
+		}
+	}
+	return err
+}
+
+// maxStderrLineSize is the length stderr lines are truncated to.
+const maxStderrLineSize = 64 * 1024
+
+// readStderr reads stderr line by line, keeping recent lines for
+// ProcessError and passing each line to the callback if configured.
+// Long lines are truncated rather than ending the read, so the CLI never
+// blocks writing to stderr.
 func (st *SubprocessTransport) readStderr() {
-	scanner := bufio.NewScanner(st.stderr)
-	for scanner.Scan() {
-		line := scanner.Text()
+	if st.stderrDone != nil {
+		defer close(st.stderrDone)
+	}
+
+	reader := newLineReader(st.stderr, maxStderrLineSize)
+	for {
+		data, err := reader.readLine()
+		var tooLarge *MessageTooLargeError
+		truncated := errors.As(err, &tooLarge)
+		if err != nil && !truncated && len(data) == 0 {
+			return
+		}
+
+		line := string(data)
+		if st.stderrTail != nil {
+			st.stderrTail.add(line)
+		}
 		if st.cfg.stderrCallback != nil {
 			st.cfg.stderrCallback(line)
 		}
+		if err != nil && !truncated {
+			return
+		}
 	}
 }
 
@@ -442,16 +647,22 @@ func (st *SubprocessTransport) Errors() <-chan error {
 	return st.errors
 }
 
-// Close terminates the subprocess.
+// Close shuts down the subprocess. It closes stdin and waits for the CLI
+// to exit, escalating to SIGTERM and then SIGKILL of the process group if
+// the CLI does not exit within the grace period (see
+// WithShutdownGracePeriod). It returns nil if the CLI exited cleanly, or a
+// *ProcessError with its exit status otherwise.
 func (st *SubprocessTransport) Close() error {
 	st.mu.Lock()
-	defer st.mu.Unlock()
-
 	if !st.ready {
+		st.mu.Unlock()
 		return nil
 	}
 
 	st.ready = false
+	if st.closed != nil {
+		close(st.closed)
+	}
 
 	// Close stdin to signal we're done
 	if st.stdin != nil {
@@ -459,12 +670,50 @@ func (st *SubprocessTransport) Close() error {
 		st.stdin = nil
 	}
 
-	// Kill the process if still running
-	if st.cmd != nil && st.cmd.Process != nil {
-		_ = st.cmd.Process.Kill()
+	cmd, exited := st.cmd, st.exited
+	st.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil || exited == nil {
+		return nil
 	}
+	return st.shutdown(cmd.Process, exited)
+}
 
-	return nil
+// shutdown waits for the process to exit, escalating through SIGTERM and
+// SIGKILL, and returns its exit status.
+func (st *SubprocessTransport) shutdown(process *os.Process, exited <-chan struct{}) error {
+	grace := st.cfg.shutdownGrace()
+
+	if !waitClosed(exited, grace) {
+		_ = terminateProcessGroup(process)
+		if !waitClosed(exited, grace) {
+			_ = killProcessGroup(process)
+			if !waitClosed(exited, grace) {
+				// A process outside the group still holds the pipes open.
+				_ = st.stdout.Close()
+				_ = st.stderr.Close()
+				<-exited
+			}
+		}
+	}
+
+	// Stop anything the CLI left running in its process group.
+	_ = killProcessGroup(process)
+
+	return st.processError(st.waitErr)
+}
+
+// waitClosed waits up to d for ch to be closed.
+func waitClosed(ch <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+		return false
+	}
 }
 
 // IsReady returns true if the transport is ready for communication.
@@ -473,3 +722,45 @@ func (st *SubprocessTransport) IsReady() bool {
 	defer st.mu.RUnlock()
 	return st.ready
 }
+
+// lineRing is a fixed-size buffer that keeps the most recent lines added.
+type lineRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size)}
+}
+
+// add appends a line, overwriting the oldest one when full.
+func (r *lineRing) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// String returns the buffered lines, oldest first, joined by newlines.
+func (r *lineRing) String() string {
+	if r == nil {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return strings.Join(r.lines[:r.next], "\n")
+	}
+	ordered := make([]string, 0, len(r.lines))
+	ordered = append(ordered, r.lines[r.next:]...)
+	ordered = append(ordered, r.lines[:r.next]...)
+	return strings.Join(ordered, "\n")
+}
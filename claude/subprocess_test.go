@@ -1,13 +1,16 @@
 package claude
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFindCLI(t *testing.T) {
@@ -204,6 +207,31 @@ func TestSubprocessTransport_BuildCommand(t *testing.T) {
 		}
 	})
 
+	t.Run("includes permission prompt tool when canUseTool is set", func(t *testing.T) {
+		cfg := &config{
+			canUseTool: func(ctx context.Context, toolName string, input map[string]any, permCtx *ToolPermissionContext) (PermissionResult, error) {
+				return PermissionResult{Allow: true}, nil
+			},
+		}
+		st := &SubprocessTransport{
+			cliPath: "/usr/bin/claude",
+			cfg:     cfg,
+		}
+
+		cmd := st.buildCommand()
+
+		containsPromptTool := false
+		for i, arg := range cmd {
+			if arg == "--permission-prompt-tool" && i+1 < len(cmd) && cmd[i+1] == "stdio" {
+				containsPromptTool = true
+				break
+			}
+		}
+		if !containsPromptTool {
+			t.Errorf("command should contain --permission-prompt-tool stdio, got %v", cmd)
+		}
+	})
+
 	t.Run("includes max budget usd flag", func(t *testing.T) {
 		cfg := &config{maxBudgetUSD: 1.5}
 		st := &SubprocessTransport{
@@ -309,6 +337,29 @@ func TestSubprocessTransport_BuildCommand(t *testing.T) {
 		}
 	})
 
+	t.Run("includes sdk mcp servers as mcp config json", func(t *testing.T) {
+		cfg := &config{}
+		applyOptions(cfg, WithMCPServers(NewSDKMCPServer("calc", "1.0.0")))
+		st := &SubprocessTransport{
+			cliPath: "/usr/bin/claude",
+			cfg:     cfg,
+		}
+
+		cmd := st.buildCommand()
+
+		want := `{"mcpServers":{"calc":{"name":"calc","type":"sdk"}}}`
+		containsServers := false
+		for i, arg := range cmd {
+			if arg == "--mcp-config" && i+1 < len(cmd) && cmd[i+1] == want {
+				containsServers = true
+				break
+			}
+		}
+		if !containsServers {
+			t.Errorf("command should contain --mcp-config %s, got %v", want, cmd)
+		}
+	})
+
 	t.Run("file checkpointing not in CLI args", func(t *testing.T) {
 		// File checkpointing is enabled via environment variable in Connect(),
 		// not as a CLI flag (matching Python SDK behavior).
@@ -755,6 +806,357 @@ func TestSubprocessTransport_ReadMessages(t *testing.T) {
 			t.Fatalf("got %d messages, want 1 (empty lines should be skipped)", len(messages))
 		}
 	})
+
+	t.Run("reads lines of any length", func(t *testing.T) {
+		st := NewSubprocessTransport(&config{})
+		large := `{"type":"user","content":"` + strings.Repeat("x", 3*1024*1024) + `"}`
+
+		r, w, _ := os.Pipe()
+		go func() {
+			w.Write([]byte(large + "\n"))
+			w.Write([]byte(`{"type":"result"}` + "\n"))
+			w.Close()
+		}()
+
+		go st.readMessages(r)
+
+		var messages [][]byte
+		for msg := range st.Messages() {
+			messages = append(messages, msg)
+		}
+
+		if len(messages) != 2 || string(messages[0]) != large {
+			t.Fatalf("got %d messages, want the large line and the result", len(messages))
+		}
+		if err, ok := <-st.Errors(); ok {
+			t.Errorf("Errors() = %v, want none", err)
+		}
+	})
+
+	t.Run("skips lines over the limit and keeps reading", func(t *testing.T) {
+		var reported []error
+		st := NewSubprocessTransport(&config{
+			maxBufferSize: 1024,
+			messageErrorCallback: func(err error) {
+				reported = append(reported, err)
+			},
+		})
+
+		large := `{"type":"user","content":"` + strings.Repeat("x", 200*1024) + `"}`
+
+		r, w, _ := os.Pipe()
+		go func() {
+			w.Write([]byte(large + "\n"))
+			w.Write([]byte(`{"type":"result"}` + "\n"))
+			w.Close()
+		}()
+
+		go st.readMessages(r)
+
+		var messages [][]byte
+		for msg := range st.Messages() {
+			messages = append(messages, msg)
+		}
+
+		if len(messages) != 1 || string(messages[0]) != `{"type":"result"}` {
+			t.Fatalf("messages = %q, want only the result", messages)
+		}
+		want := &MessageTooLargeError{Size: len(large), Limit: 1024}
+		if len(reported) != 1 || *reported[0].(*MessageTooLargeError) != *want {
+			t.Errorf("reported = %v, want [%v]", reported, want)
+		}
+	})
+}
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		limit     int
+		want      []string
+		tooLarge  int
+		truncated string
+	}{
+		{name: "splits lines", input: "a\nbc\n", want: []string{"a", "bc"}},
+		{name: "strips CRLF", input: "a\r\nb\r\n", want: []string{"a", "b"}},
+		{name: "returns last line without newline", input: "a\nb", want: []string{"a", "b"}},
+		{name: "accepts line at the limit", input: "abcd\r\n", limit: 4, want: []string{"abcd"}},
+		{name: "rejects line over the limit", input: "abcde\nab\n", limit: 4, want: []string{"ab"}, tooLarge: 5, truncated: "abcd"},
+		{name: "rejects long line over the limit", input: strings.Repeat("a", 100) + "\r\nab\n", limit: 4, want: []string{"ab"}, tooLarge: 100, truncated: "aaaa"},
+		{name: "truncates long line to the limit", input: strings.Repeat("a", 30) + "b" + strings.Repeat("c", 30) + "\n", limit: 31, tooLarge: 61, truncated: strings.Repeat("a", 30) + "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &lineReader{r: bufio.NewReaderSize(strings.NewReader(tt.input), 16), limit: tt.limit}
+
+			var lines []string
+			tooLarge := 0
+			truncated := ""
+			for {
+				line, err := reader.readLine()
+				var sizeErr *MessageTooLargeError
+				if errors.As(err, &sizeErr) {
+					tooLarge = sizeErr.Size
+					truncated = string(line)
+					continue
+				}
+				if len(line) > 0 {
+					lines = append(lines, string(line))
+				}
+				if err != nil {
+					break
+				}
+			}
+
+			if strings.Join(lines, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("lines = %q, want %q", lines, tt.want)
+			}
+			if tooLarge != tt.tooLarge {
+				t.Errorf("too large size = %d, want %d", tooLarge, tt.tooLarge)
+			}
+			if truncated != tt.truncated {
+				t.Errorf("truncated line = %q, want %q", truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestLineReaderKeepsLines(t *testing.T) {
+	input := "first\n" + strings.Repeat("b", 100*1024) + "\nabc\n"
+	reader := newLineReader(strings.NewReader(input), 0)
+
+	var lines [][]byte
+	for {
+		line, err := reader.readLine()
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	_ = append(lines[0], "xyz"...)
+
+	if len(lines) != 3 {
+		t.Fatalf("read %d lines, want 3", len(lines))
+	}
+	if string(lines[0]) != "first" || len(lines[1]) != 100*1024 || string(lines[2]) != "abc" {
+		t.Errorf("lines were overwritten: %q, %d bytes, %q", lines[0], len(lines[1]), lines[2])
+	}
+}
+
+func BenchmarkLineReader(b *testing.B) {
+	line := `{"type":"stream_event","uuid":"5c1d7a2e-4f8b-4a8e-9d3c-2b1e6f0a7c94","session_id":"s",` +
+		`"event":{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello, world"}}}` + "\n"
+	input := strings.Repeat(line, 1000)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for b.Loop() {
+		reader := newLineReader(strings.NewReader(input), 0)
+		for {
+			if _, err := reader.readLine(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// writeTestCLI writes a shell script standing in for the CLI.
+func writeTestCLI(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o700); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	return path
+}
+
+func TestSubprocessTransport_ProcessError(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("requires a POSIX shell")
+	}
+
+	t.Run("non-zero exit produces ProcessError with stderr tail", func(t *testing.T) {
+		cfg := &config{cliPath: writeTestCLI(t, "echo first >&2\necho second >&2\nexit 3\n")}
+		st := NewSubprocessTransport(cfg)
+		if err := st.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer st.Close()
+
+		for range st.Messages() {
+		}
+
+		var procErr *ProcessError
+		err := <-st.Errors()
+		if !errors.As(err, &procErr) {
+			t.Fatalf("error = %v, want *ProcessError", err)
+		}
+		if procErr.ExitCode != 3 {
+			t.Errorf("ExitCode = %d, want 3", procErr.ExitCode)
+		}
+		if procErr.Stderr != "first\nsecond" {
+			t.Errorf("Stderr = %q, want 'first\\nsecond'", procErr.Stderr)
+		}
+	})
+
+	t.Run("long stderr line does not stall stdout", func(t *testing.T) {
+		script := "head -c 100000 /dev/zero | tr '\\0' x >&2\necho >&2\n" +
+			"i=0\nwhile [ $i -lt 10000 ]; do echo \"line $i\" >&2; i=$((i+1)); done\n" +
+			"echo '{\"type\":\"result\"}'\nexit 3\n"
+		cfg := &config{cliPath: writeTestCLI(t, script)}
+		st := NewSubprocessTransport(cfg)
+		if err := st.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer st.Close()
+
+		select {
+		case msg := <-st.Messages():
+			if string(msg) != `{"type":"result"}` {
+				t.Errorf("message = %q, want the result", msg)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("stdout stalled behind a long stderr line")
+		}
+		for range st.Messages() {
+		}
+
+		var procErr *ProcessError
+		if err := <-st.Errors(); !errors.As(err, &procErr) {
+			t.Fatalf("error = %v, want *ProcessError", err)
+		}
+		if !strings.HasSuffix(procErr.Stderr, "line 9999") {
+			t.Errorf("Stderr tail = %q, want it to end with the last line", procErr.Stderr[max(0, len(procErr.Stderr)-40):])
+		}
+	})
+
+	t.Run("clean exit reports no error", func(t *testing.T) {
+		cfg := &config{cliPath: writeTestCLI(t, "exit 0\n")}
+		st := NewSubprocessTransport(cfg)
+		if err := st.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer st.Close()
+
+		for range st.Messages() {
+		}
+
+		if err, ok := <-st.Errors(); ok {
+			t.Errorf("unexpected error = %v", err)
+		}
+	})
+}
+
+func TestSubprocessTransport_Backpressure(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("requires a POSIX shell")
+	}
+
+	t.Run("slow consumer receives every message", func(t *testing.T) {
+		cfg := &config{
+			cliPath:           writeTestCLI(t, "i=0\nwhile [ $i -lt 50 ]; do echo '{\"type\":\"assistant\"}'; i=$((i+1)); done\n"),
+			messageBufferSize: 2,
+		}
+		st := NewSubprocessTransport(cfg)
+		if err := st.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer st.Close()
+
+		time.Sleep(50 * time.Millisecond)
+
+		count := 0
+		for range st.Messages() {
+			count++
+		}
+		if count != 50 {
+			t.Errorf("received %d messages, want 50", count)
+		}
+	})
+
+	t.Run("drop policy drops only stream events", func(t *testing.T) {
+		cfg := &config{
+			cliPath: writeTestCLI(t, "i=0\nwhile [ $i -lt 10 ]; do echo '{\"type\":\"stream_event\"}'; i=$((i+1)); done\n"+
+				"echo '{\"type\":\"result\"}'\n"),
+			messageBufferSize: 1,
+			overflowPolicy:    OverflowDropStreamEvents,
+		}
+		st := NewSubprocessTransport(cfg)
+		if err := st.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		defer st.Close()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for st.DroppedMessages() < 9 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		var messages []string
+		for msg := range st.Messages() {
+			messages = append(messages, string(msg))
+		}
+
+		if st.DroppedMessages() != 9 {
+			t.Errorf("DroppedMessages() = %d, want 9", st.DroppedMessages())
+		}
+		if len(messages) != 2 || messages[1] != `{"type":"result"}` {
+			t.Errorf("messages = %v, want one stream event and the result", messages)
+		}
+	})
+
+	t.Run("Close releases a reader blocked on a full buffer", func(t *testing.T) {
+		cfg := &config{
+			cliPath:             writeTestCLI(t, "i=0\nwhile [ $i -lt 10 ]; do echo '{\"type\":\"assistant\"}'; i=$((i+1)); done\nsleep 5\n"),
+			messageBufferSize:   1,
+			shutdownGracePeriod: 50 * time.Millisecond,
+		}
+		st := NewSubprocessTransport(cfg)
+		if err := st.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		_ = st.Close()
+
+		done := make(chan struct{})
+		go func() {
+			for range st.Messages() {
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("Messages() not closed after Close()")
+		}
+	})
+}
+
+func TestLineRing(t *testing.T) {
+	t.Run("keeps lines in order before wrapping", func(t *testing.T) {
+		r := newLineRing(3)
+		r.add("a")
+		r.add("b")
+
+		if got := r.String(); got != "a\nb" {
+			t.Errorf("String() = %q, want 'a\\nb'", got)
+		}
+	})
+
+	t.Run("keeps only the most recent lines", func(t *testing.T) {
+		r := newLineRing(3)
+		for _, line := range []string{"a", "b", "c", "d", "e"} {
+			r.add(line)
+		}
+
+		if got := r.String(); got != "c\nd\ne" {
+			t.Errorf("String() = %q, want 'c\\nd\\ne'", got)
+		}
+	})
 }
 
 func TestFindCLI_FallbackLocations(t *testing.T) {
@@ -1418,6 +1820,35 @@ func TestSubprocessTransport_StderrCallback(t *testing.T) {
 		}
 	})
 
+	t.Run("truncates long lines and keeps reading", func(t *testing.T) {
+		var received []string
+		cfg := &config{stderrCallback: func(line string) {
+			received = append(received, line)
+		}}
+		st := NewSubprocessTransport(cfg)
+
+		r, w, _ := os.Pipe()
+		st.stderr = r
+
+		go func() {
+			w.Write([]byte(strings.Repeat("x", 100*1024) + "\n"))
+			w.Write([]byte("after\n"))
+			w.Close()
+		}()
+
+		st.readStderr()
+
+		if len(received) != 2 {
+			t.Fatalf("got %d stderr lines, want 2", len(received))
+		}
+		if len(received[0]) != maxStderrLineSize {
+			t.Errorf("len(received[0]) = %d, want %d", len(received[0]), maxStderrLineSize)
+		}
+		if received[1] != "after" {
+			t.Errorf("received[1] = %q, want 'after'", received[1])
+		}
+	})
+
 	t.Run("handles nil callback gracefully", func(t *testing.T) {
 		cfg := &config{stderrCallback: nil}
 		st := NewSubprocessTransport(cfg)
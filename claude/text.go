@@ -0,0 +1,176 @@
+package claude
+
+import (
+	"context"
+	"io"
+)
+
+// TextStream returns a reader that yields Claude's text for the current
+// turn as it is generated. The reader reaches EOF when the turn's
+// ResultMessage arrives, or returns ResultMessage.Err if the turn failed.
+// If the session ends first, Read returns the error that ended it, or
+// ErrNoResult.
+//
+// With WithIncludePartialMessages(true) the text arrives as streaming
+// deltas; otherwise it arrives a whole text block at a time. Separate
+// text blocks are joined with a newline. Subagent output is skipped.
+//
+// TextStream consumes Messages, so other messages of the turn are not
+// delivered elsewhere. Closing the reader or cancelling ctx before the
+// ResultMessage interrupts the turn and discards its remaining messages,
+// like Turn, so the client is ready for the next prompt; Close waits for
+// this to finish. If the CLI does not end the turn within the shutdown
+// grace period (see WithShutdownGracePeriod), the session is closed.
+//
+// Example:
+//
+//	if err := client.Query(ctx, "Write a haiku"); err != nil {
+//	    return err
+//	}
+//	text := client.TextStream(ctx)
+//	defer text.Close()
+//	_, err := io.Copy(os.Stdout, text)
+func (c *Client) TextStream(ctx context.Context) io.ReadCloser {
+	return newTextStream(ctx, c, nil)
+}
+
+// QueryText sends a prompt to Claude and returns a reader that yields the
+// response text as it is generated. Partial messages are enabled unless
+// the options turn them off. The session is closed when the reader
+// reaches the end of the response or is closed.
+//
+// Example:
+//
+//	text, err := claude.QueryText(ctx, "Explain goroutines")
+//	if err != nil {
+//	    return err
+//	}
+//	defer text.Close()
+//	_, err = io.Copy(w, text)
+func QueryText(ctx context.Context, prompt string, opts ...Option) (io.ReadCloser, error) {
+	client := NewClient(append([]Option{WithIncludePartialMessages(true)}, opts...)...)
+
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := client.Query(ctx, prompt); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return newTextStream(ctx, client, client.Close), nil
+}
+
+// textStream adapts a client's messages to an io.ReadCloser.
+type textStream struct {
+	*io.PipeReader
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// newTextStream starts copying text from the client into a pipe. The
+// cleanup function, if any, runs once copying stops; otherwise a turn
+// stopped before its result is abandoned.
+func newTextStream(ctx context.Context, c *Client, cleanup func() error) *textStream {
+	ctx, stop := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	s := &textStream{PipeReader: pr, stop: stop, done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+		if cleanup != nil {
+			defer func() { _ = cleanup() }()
+		}
+		result, err := c.copyText(ctx, pw)
+		pw.CloseWithError(err)
+		if result == nil && ctx.Err() != nil && cleanup == nil && c.IsConnected() {
+			_, _ = c.abandonTurn(context.WithoutCancel(ctx))
+		}
+	}()
+
+	return s
+}
+
+// Close stops the stream and waits for it to finish.
+func (s *textStream) Close() error {
+	s.stop()
+	err := s.PipeReader.Close()
+	<-s.done
+	return err
+}
+
+// copyText writes the turn's text to w until the ResultMessage. It
+// returns the result, if it arrived, and its error, which is nil if the
+// turn succeeded.
+func (c *Client) copyText(ctx context.Context, w io.Writer) (*ResultMessage, error) {
+	tw := &textWriter{w: w, partial: c.cfg.includePartialMessages}
+
+	var result *ResultMessage
+	var err error
+	c.receiveTurn(ctx, func(msg Message, turnErr error) bool {
+		if turnErr != nil {
+			err = turnErr
+			return false
+		}
+		if m, ok := msg.(*ResultMessage); ok {
+			result, err = m, m.Err()
+			return false
+		}
+		err = tw.writeMessage(msg)
+		return err == nil
+	})
+	return result, err
+}
+
+// textWriter writes the main conversation's text from messages, taking
+// it from stream events when partial messages are enabled and from whole
+// assistant messages otherwise.
+type textWriter struct {
+	w         io.Writer
+	partial   bool
+	wroteText bool
+}
+
+// writeMessage writes the text carried by msg, if any.
+func (t *textWriter) writeMessage(msg Message) error {
+	switch m := msg.(type) {
+	case *StreamEvent:
+		if !t.partial || m.ParentToolUseID != "" {
+			return nil
+		}
+		switch {
+		case m.Type == StreamContentBlockStart && m.ContentBlock != nil && m.ContentBlock.IsText():
+			return t.write(m.ContentBlock.Text, true)
+		case m.Type == StreamContentBlockDelta && m.Delta != nil && m.Delta.Type == DeltaText:
+			return t.write(m.Delta.Text, false)
+		}
+
+	case *AssistantMessage:
+		if t.partial || m.ParentToolUseID != "" {
+			return nil
+		}
+		for _, block := range m.Content {
+			if !block.IsText() {
+				continue
+			}
+			if err := t.write(block.Text, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write writes text, separating it from earlier text with a newline when
+// it starts a new block.
+func (t *textWriter) write(text string, newBlock bool) error {
+	if newBlock && t.wroteText {
+		if _, err := io.WriteString(t.w, "\n"); err != nil {
+			return err
+		}
+	}
+	t.wroteText = true
+	_, err := io.WriteString(t.w, text)
+	return err
+}
@@ -0,0 +1,236 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// queueJSON queues each raw JSON line on the mock transport.
+func queueJSON(t *testing.T, mt *mockTransport, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Fatalf("invalid JSON: %s", line)
+		}
+		mt.QueueMessage([]byte(line))
+	}
+}
+
+const testResultJSON = `{"type":"result","subtype":"success","session_id":"s","num_turns":1}`
+
+func TestClientTextStream(t *testing.T) {
+	t.Run("streams text deltas with partial messages", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt,
+			`{"type":"stream_event","event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}`,
+			`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello, "}}}`,
+			`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"world"}}}`,
+			`{"type":"stream_event","parent_tool_use_id":"task-1","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"subagent"}}}`,
+			`{"type":"stream_event","event":{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}}`,
+			`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Done."}}}`,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"Hello, world"}],"model":"m"}}`,
+			testResultJSON,
+		)
+		client := NewClient(WithTransport(mt), WithIncludePartialMessages(true))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		text := client.TextStream(context.Background())
+		defer text.Close()
+		got, err := io.ReadAll(text)
+
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		if string(got) != "Hello, world\nDone." {
+			t.Errorf("text = %q, want %q", got, "Hello, world\nDone.")
+		}
+	})
+
+	t.Run("falls back to assistant messages without partial messages", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"First"},{"type":"tool_use","id":"t","name":"Read","input":{}}],"model":"m"}}`,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"Second"}],"model":"m"}}`,
+			testResultJSON,
+		)
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		got, err := io.ReadAll(client.TextStream(context.Background()))
+
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		if string(got) != "First\nSecond" {
+			t.Errorf("text = %q, want %q", got, "First\nSecond")
+		}
+	})
+
+	t.Run("stops at the result and leaves later messages", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"one"}],"model":"m"}}`,
+			testResultJSON,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"two"}],"model":"m"}}`,
+		)
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		got, _ := io.ReadAll(client.TextStream(context.Background()))
+
+		if string(got) != "one" {
+			t.Errorf("text = %q, want %q", got, "one")
+		}
+		next, ok := (<-client.Messages()).(*AssistantMessage)
+		if !ok || next.Content[0].Text != "two" {
+			t.Errorf("next message = %+v, want second assistant message", next)
+		}
+	})
+
+	t.Run("returns ErrNoResult when the session ends first", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt, `{"type":"assistant","message":{"content":[{"type":"text","text":"partial"}],"model":"m"}}`)
+		mt.CloseMessages()
+		client := NewClient(WithTransport(mt))
+		_ = client.Connect(context.Background())
+		defer client.Close()
+
+		got, err := io.ReadAll(client.TextStream(context.Background()))
+
+		if !errors.Is(err, ErrNoResult) {
+			t.Errorf("ReadAll() error = %v, want %v", err, ErrNoResult)
+		}
+		if string(got) != "partial" {
+			t.Errorf("text = %q, want %q", got, "partial")
+		}
+	})
+
+	t.Run("Close interrupts the turn and discards the rest of it", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+			queueJSON(t, mt,
+				`{"type":"assistant","message":{"content":[{"type":"text","text":"one"}],"model":"m"}}`,
+				`{"type":"assistant","message":{"content":[{"type":"text","text":"two"}],"model":"m"}}`,
+				testResultJSON,
+				`{"type":"assistant","message":{"content":[{"type":"text","text":"next turn"}],"model":"m"}}`,
+			)
+
+			text := client.TextStream(context.Background())
+			if _, err := text.Read(make([]byte, 1)); err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if err := text.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+
+			if len(mt.sentMessages) != 1 || !strings.Contains(string(mt.sentMessages[0]), "interrupt") {
+				t.Errorf("sentMessages = %q, want an interrupt", mt.sentMessages)
+			}
+			next, ok := (<-client.Messages()).(*AssistantMessage)
+			if !ok || next.Content[0].Text != "next turn" {
+				t.Errorf("next message = %+v, want the next turn's message", next)
+			}
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("Close stops a stream that is waiting", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+
+			text := client.TextStream(context.Background())
+			closed := make(chan error)
+			go func() { closed <- text.Close() }()
+			synctest.Wait()
+			queueJSON(t, mt, testResultJSON)
+
+			if err := <-closed; err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+			if _, err := text.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+				t.Errorf("Read() after Close error = %v, want %v", err, io.ErrClosedPipe)
+			}
+			if !client.IsConnected() {
+				t.Error("client should stay connected")
+			}
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("Close ends the session if the interrupt is never acknowledged", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+			mt.IgnoreControl()
+
+			text := client.TextStream(context.Background())
+			start := time.Now()
+			if err := text.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+
+			if elapsed := time.Since(start); elapsed != defaultShutdownGracePeriod {
+				t.Errorf("Close() took %v, want %v", elapsed, defaultShutdownGracePeriod)
+			}
+			if client.IsConnected() {
+				t.Error("client should be closed")
+			}
+			if err := client.Err(); !errors.Is(err, ErrCLIConnection) {
+				t.Errorf("Err() = %v, want %v", err, ErrCLIConnection)
+			}
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("fails when not connected", func(t *testing.T) {
+		_, err := io.ReadAll(NewClient().TextStream(context.Background()))
+
+		if !errors.Is(err, ErrNotConnected) {
+			t.Errorf("ReadAll() error = %v, want %v", err, ErrNotConnected)
+		}
+	})
+}
+
+func TestQueryText(t *testing.T) {
+	t.Run("streams response text and closes the session", func(t *testing.T) {
+		mt := newMockTransport()
+		queueJSON(t, mt,
+			`{"type":"stream_event","event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}`,
+			`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"4"}}}`,
+			testResultJSON,
+		)
+
+		text, err := QueryText(context.Background(), "What is 2+2?", WithTransport(mt))
+		if err != nil {
+			t.Fatalf("QueryText() error = %v", err)
+		}
+		got, err := io.ReadAll(text)
+		_ = text.Close()
+
+		if err != nil || string(got) != "4" {
+			t.Errorf("ReadAll() = %q, %v, want \"4\"", got, err)
+		}
+		if mt.IsReady() {
+			t.Error("transport should be closed")
+		}
+	})
+
+	t.Run("returns error on connection failure", func(t *testing.T) {
+		mt := newMockTransport()
+		mt.connectErr = errors.New("connection failed")
+
+		_, err := QueryText(context.Background(), "hi", WithTransport(mt))
+
+		if err == nil {
+			t.Error("QueryText() error = nil, want error")
+		}
+	})
+}
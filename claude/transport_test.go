@@ -2,7 +2,9 @@ package claude
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"sync"
 	"testing"
 )
 
@@ -141,14 +143,28 @@ func TestMockTransport(t *testing.T) {
 }
 
 // mockTransport is a test implementation of Transport.
+//
+// Like the CLI, it answers every control_request it is sent with a
+// control_response. Set controlErr to answer with an error response, or
+// call IgnoreControl to leave requests unanswered. Initialize requests are
+// answered with initResponse and recorded in initRequests rather than
+// sentMessages. controlErr does not apply to initialize requests.
 type mockTransport struct {
-	ready        bool
-	connectErr   error
-	sendErr      error
-	closeErr     error
-	sentMessages [][]byte
-	messagesCh   chan []byte
-	errorsCh     chan error
+	ready         bool
+	connectErr    error
+	sendErr       error
+	closeErr      error
+	onClose       func()
+	controlErr    string
+	ignoreControl bool
+	initResponse  map[string]any
+	initRequests  [][]byte
+	sentMessages  [][]byte
+	messagesCh    chan []byte
+	errorsCh      chan error
+	closed        bool
+	closeOnInit   bool
+	mu            sync.Mutex
 }
 
 func newMockTransport() *mockTransport {
@@ -162,7 +178,9 @@ func (m *mockTransport) Connect(ctx context.Context) error {
 	if m.connectErr != nil {
 		return m.connectErr
 	}
+	m.mu.Lock()
 	m.ready = true
+	m.mu.Unlock()
 	return nil
 }
 
@@ -173,16 +191,64 @@ func (m *mockTransport) Send(ctx context.Context, data []byte) error {
 	default:
 	}
 
+	m.mu.Lock()
 	if !m.ready {
+		m.mu.Unlock()
 		return ErrNotConnected
 	}
 	if m.sendErr != nil {
+		m.mu.Unlock()
 		return m.sendErr
 	}
-	m.sentMessages = append(m.sentMessages, data)
+	m.mu.Unlock()
+
+	var req ControlRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Type != MessageTypeControlRequest {
+		m.mu.Lock()
+		m.sentMessages = append(m.sentMessages, data)
+		m.mu.Unlock()
+		return nil
+	}
+
+	m.respondToControl(data, &req)
 	return nil
 }
 
+// respondToControl records an outgoing control_request and queues a
+// control_response for it.
+func (m *mockTransport) respondToControl(data []byte, req *ControlRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	isInit := req.Request != nil && req.Request.Subtype == ControlSubtypeInitialize
+	if isInit {
+		m.initRequests = append(m.initRequests, data)
+	} else {
+		m.sentMessages = append(m.sentMessages, data)
+	}
+
+	if m.ignoreControl || m.closed {
+		return
+	}
+
+	var resp *ControlResponse
+	switch {
+	case isInit && m.initResponse != nil:
+		resp = NewControlResponseSuccess(req.RequestID, m.initResponse)
+	case isInit || m.controlErr == "":
+		resp = NewControlResponseSuccess(req.RequestID, map[string]any{})
+	default:
+		resp = NewControlResponseError(req.RequestID, m.controlErr)
+	}
+	respBytes, _ := json.Marshal(resp)
+	m.messagesCh <- respBytes
+
+	if isInit && m.closeOnInit {
+		m.closed = true
+		close(m.messagesCh)
+	}
+}
+
 func (m *mockTransport) Messages() <-chan []byte {
 	return m.messagesCh
 }
@@ -192,21 +258,46 @@ func (m *mockTransport) Errors() <-chan error {
 }
 
 func (m *mockTransport) Close() error {
+	m.mu.Lock()
 	m.ready = false
+	onClose := m.onClose
+	m.mu.Unlock()
+	if onClose != nil {
+		onClose()
+	}
 	return m.closeErr
 }
 
 func (m *mockTransport) IsReady() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.ready
 }
 
 // Test helpers
 
+// IgnoreControl stops answering control requests.
+func (m *mockTransport) IgnoreControl() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ignoreControl = true
+}
+
 func (m *mockTransport) QueueMessage(data []byte) {
 	m.messagesCh <- data
 }
 
+// CloseMessages closes the message channel. Before Connect, closing is
+// deferred until the initialize request has been answered, like a CLI that
+// replays queued output and exits.
 func (m *mockTransport) CloseMessages() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.ready && !m.ignoreControl {
+		m.closeOnInit = true
+		return
+	}
+	m.closed = true
 	close(m.messagesCh)
 }
 
@@ -0,0 +1,162 @@
+package claude
+
+import (
+	"context"
+	"errors"
+)
+
+// Turn is a prompt sent with Client.Send and the response to it.
+//
+// A Turn's messages are delivered on its own channel and end with the
+// ResultMessage, so callers do not need to find the end of the turn in
+// Client.Messages themselves.
+type Turn struct {
+	client  *Client
+	content any
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	messages chan Message
+	done     chan struct{}
+
+	// released is closed once this turn and every earlier turn have
+	// ended. The next turn waits for it rather than done, so cancelling
+	// a queued turn does not let later turns overtake a running one.
+	released chan struct{}
+
+	// result and err are set before done is closed.
+	result *ResultMessage
+	err    error
+}
+
+// Send queues a prompt and returns its Turn. The prompt is sent once every
+// earlier Turn from Send has finished, so turns run in the order they were
+// sent. Connect must be called before Send.
+//
+// Turns read from Messages, so do not mix Send with Query, Turn or reading
+// Messages directly. Cancelling ctx cancels the turn, like Turn.Cancel.
+//
+// Example:
+//
+//	turn := client.Send(ctx, "Summarize README.md")
+//	for msg := range turn.Messages() {
+//	    fmt.Printf("%T\n", msg)
+//	}
+//	result, err := turn.Wait()
+func (c *Client) Send(ctx context.Context, prompt string) *Turn {
+	t := &Turn{
+		client:   c,
+		content:  prompt,
+		messages: make(chan Message, c.cfg.messageBuffer()),
+		done:     make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+
+	c.turnMu.Lock()
+	prev := c.lastTurn
+	c.lastTurn = t
+	c.turnMu.Unlock()
+
+	go t.run(prev)
+	return t
+}
+
+// Messages returns the turn's messages, ending with its ResultMessage. The
+// channel is closed when the turn ends.
+func (t *Turn) Messages() <-chan Message {
+	return t.messages
+}
+
+// Wait blocks until the turn ends and returns its ResultMessage. Messages
+// not yet received from Messages are discarded, so call Wait after
+// ranging over Messages, or instead of it.
+//
+// If the turn ended with an error, Wait returns the ResultMessage along
+// with ResultMessage.Err, such as ErrMaxTurns or an *APIError.
+// If the turn was cancelled, Wait returns the context error along with the
+// ResultMessage of the interrupted turn, if the CLI sent one. If the CLI
+// does not end the interrupted turn within the shutdown grace period, the
+// session is closed and the error also wraps the reason. If the session
+// ends before the result, Wait returns the error that ended it, or
+// ErrNoResult.
+func (t *Turn) Wait() (*ResultMessage, error) {
+	for range t.messages {
+	}
+	<-t.done
+	return t.result, t.err
+}
+
+// Done returns a channel that is closed when the turn ends.
+func (t *Turn) Done() <-chan struct{} {
+	return t.done
+}
+
+// Cancel cancels the turn. A queued turn is never sent. A running turn is
+// interrupted, and its remaining messages are discarded so the next turn
+// starts cleanly. Cancel does not wait for the turn to end; use Wait.
+func (t *Turn) Cancel() {
+	t.cancel()
+}
+
+// run runs the turn, then releases the queue once earlier turns have
+// also ended.
+func (t *Turn) run(prev *Turn) {
+	defer close(t.released)
+
+	t.process(prev)
+	if prev != nil {
+		<-prev.released
+	}
+}
+
+// process waits for the previous turn, then sends the prompt and forwards
+// the response.
+func (t *Turn) process(prev *Turn) {
+	defer t.cancel()
+	defer close(t.done)
+	defer close(t.messages)
+
+	if prev != nil {
+		select {
+		case <-prev.released:
+		case <-t.ctx.Done():
+			t.err = t.ctx.Err()
+			return
+		}
+	}
+
+	c := t.client
+	if err := c.sendUserMessage(t.ctx, t.content); err != nil {
+		t.err = err
+		return
+	}
+
+	c.receiveTurn(t.ctx, func(msg Message, err error) bool {
+		if err != nil {
+			t.err = err
+			return false
+		}
+		if result, ok := msg.(*ResultMessage); ok {
+			t.result = result
+		}
+		select {
+		case t.messages <- msg:
+			return true
+		case <-t.ctx.Done():
+			t.err = t.ctx.Err()
+			return false
+		}
+	})
+
+	switch {
+	case t.result == nil && t.ctx.Err() != nil && c.IsConnected():
+		result, err := c.abandonTurn(context.WithoutCancel(t.ctx))
+		t.result = result
+		if err != nil {
+			t.err = errors.Join(t.err, err)
+		}
+	case t.err == nil && t.result != nil:
+		t.err = t.result.Err()
+	}
+}
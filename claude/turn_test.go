@@ -0,0 +1,223 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/synctest"
+)
+
+const testAssistantJSON = `{"type":"assistant","message":{"content":[{"type":"text","text":"hi"}],"model":"m"}}`
+
+// connectedClient returns a client connected to a new mock transport.
+func connectedClient(t *testing.T) (*Client, *mockTransport) {
+	t.Helper()
+	mt := newMockTransport()
+	client := NewClient(WithTransport(mt))
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	return client, mt
+}
+
+// closeSession closes the client and its mock transport's messages so
+// no goroutines outlive a synctest bubble.
+func closeSession(client *Client, mt *mockTransport) {
+	_ = client.Close()
+	close(mt.messagesCh)
+	synctest.Wait()
+}
+
+func TestClientSend(t *testing.T) {
+	t.Run("delivers the turn's messages and result", func(t *testing.T) {
+		client, mt := connectedClient(t)
+		defer client.Close()
+		queueJSON(t, mt, testAssistantJSON, testResultJSON)
+
+		turn := client.Send(context.Background(), "hello")
+		var received []Message
+		for msg := range turn.Messages() {
+			received = append(received, msg)
+		}
+		result, err := turn.Wait()
+
+		if err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+		if len(received) != 2 || received[1] != result {
+			t.Errorf("received = %v, want assistant message then result", received)
+		}
+		if result.SessionID != "s" {
+			t.Errorf("SessionID = %q, want %q", result.SessionID, "s")
+		}
+	})
+
+	t.Run("Wait discards unread messages", func(t *testing.T) {
+		client, mt := connectedClient(t)
+		defer client.Close()
+		queueJSON(t, mt, testAssistantJSON, testAssistantJSON, testResultJSON)
+
+		result, err := client.Send(context.Background(), "hello").Wait()
+
+		if err != nil || result == nil {
+			t.Errorf("Wait() = %v, %v, want result", result, err)
+		}
+	})
+
+	t.Run("queues turns in order", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+
+			first := client.Send(context.Background(), "first")
+			second := client.Send(context.Background(), "second")
+			synctest.Wait()
+
+			if len(mt.sentMessages) != 1 || !strings.Contains(string(mt.sentMessages[0]), "first") {
+				t.Fatalf("sentMessages = %q, want only the first prompt", mt.sentMessages)
+			}
+
+			queueJSON(t, mt, testResultJSON)
+			if _, err := first.Wait(); err != nil {
+				t.Fatalf("first Wait() error = %v", err)
+			}
+			synctest.Wait()
+
+			if len(mt.sentMessages) != 2 || !strings.Contains(string(mt.sentMessages[1]), "second") {
+				t.Fatalf("sentMessages = %q, want the second prompt after the first result", mt.sentMessages)
+			}
+
+			queueJSON(t, mt, testResultJSON)
+			if _, err := second.Wait(); err != nil {
+				t.Errorf("second Wait() error = %v", err)
+			}
+
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("cancelled queued turn is never sent", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+
+			first := client.Send(context.Background(), "first")
+			second := client.Send(context.Background(), "second")
+			third := client.Send(context.Background(), "third")
+			second.Cancel()
+
+			if _, err := second.Wait(); !errors.Is(err, context.Canceled) {
+				t.Errorf("second Wait() error = %v, want %v", err, context.Canceled)
+			}
+
+			queueJSON(t, mt, testResultJSON, testResultJSON)
+			_, _ = first.Wait()
+			_, _ = third.Wait()
+
+			if len(mt.sentMessages) != 2 || !strings.Contains(string(mt.sentMessages[1]), "third") {
+				t.Errorf("sentMessages = %q, want first and third prompts", mt.sentMessages)
+			}
+
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("cancelled running turn is interrupted and drained", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+			queueJSON(t, mt, testAssistantJSON)
+
+			turn := client.Send(context.Background(), "first")
+			<-turn.Messages()
+			synctest.Wait()
+			turn.Cancel()
+			synctest.Wait()
+			queueJSON(t, mt, testAssistantJSON, testResultJSON)
+			result, err := turn.Wait()
+
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+			}
+			if result == nil {
+				t.Error("Wait() result = nil, want the interrupted turn's result")
+			}
+			if len(mt.sentMessages) != 2 || !strings.Contains(string(mt.sentMessages[1]), "interrupt") {
+				t.Errorf("sentMessages = %q, want prompt and interrupt", mt.sentMessages)
+			}
+
+			queueJSON(t, mt, testResultJSON)
+			next := client.Send(context.Background(), "second")
+			if msg := <-next.Messages(); msg == nil {
+				t.Error("next turn received no messages")
+			} else if _, ok := msg.(*ResultMessage); !ok {
+				t.Errorf("next turn's first message = %T, want *ResultMessage", msg)
+			}
+			_, _ = next.Wait()
+
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("cancelled turn ends the session if the interrupt is never acknowledged", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			client, mt := connectedClient(t)
+			mt.IgnoreControl()
+
+			first := client.Send(context.Background(), "first")
+			second := client.Send(context.Background(), "second")
+			synctest.Wait()
+			first.Cancel()
+
+			_, err := first.Wait()
+			if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrCLIConnection) {
+				t.Errorf("first Wait() error = %v, want %v and %v", err, context.Canceled, ErrCLIConnection)
+			}
+			if _, err := second.Wait(); !errors.Is(err, ErrNotConnected) {
+				t.Errorf("second Wait() error = %v, want %v", err, ErrNotConnected)
+			}
+			if client.IsConnected() {
+				t.Error("client should be closed")
+			}
+
+			closeSession(client, mt)
+		})
+	})
+
+	t.Run("Wait returns the result's error", func(t *testing.T) {
+		client, mt := connectedClient(t)
+		defer client.Close()
+		queueJSON(t, mt, `{"type":"result","subtype":"error_max_budget_usd","is_error":true}`)
+
+		result, err := client.Send(context.Background(), "hello").Wait()
+
+		if !errors.Is(err, ErrBudgetExceeded) || result == nil {
+			t.Errorf("Wait() = %v, %v, want result and %v", result, err, ErrBudgetExceeded)
+		}
+	})
+
+	t.Run("returns the error that ended the session", func(t *testing.T) {
+		client, mt := connectedClient(t)
+		defer client.Close()
+		mt.QueueError(&ProcessError{ExitCode: 1})
+		mt.CloseMessages()
+
+		_, err := client.Send(context.Background(), "hello").Wait()
+
+		var procErr *ProcessError
+		if !errors.As(err, &procErr) {
+			t.Errorf("Wait() error = %v, want *ProcessError", err)
+		}
+	})
+
+	t.Run("returns error when not connected", func(t *testing.T) {
+		turn := NewClient().Send(context.Background(), "hello")
+
+		if _, err := turn.Wait(); !errors.Is(err, ErrNotConnected) {
+			t.Errorf("Wait() error = %v, want %v", err, ErrNotConnected)
+		}
+		select {
+		case <-turn.Done():
+		default:
+			t.Error("Done() should be closed")
+		}
+	})
+}
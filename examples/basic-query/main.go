@@ -13,13 +13,12 @@ import (
 func main() {
 	ctx := context.Background()
 
-	msgs, err := claude.Query(ctx, "What is 2 + 2? Answer briefly.")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
+	for msg, err := range claude.Stream(ctx, "What is 2 + 2? Answer briefly.") {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 
-	for msg := range msgs {
 		switch m := msg.(type) {
 		case *claude.AssistantMessage:
 			for _, block := range m.Content {
@@ -39,26 +39,20 @@ func main() {
 			break
 		}
 
-		if err := client.Query(ctx, input); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			continue
-		}
-
 		fmt.Print("Claude: ")
-	readLoop:
-		for msg := range client.Messages() {
-			switch m := msg.(type) {
-			case *claude.AssistantMessage:
+		turn := client.Send(ctx, input)
+		for msg := range turn.Messages() {
+			if m, ok := msg.(*claude.AssistantMessage); ok {
 				for _, block := range m.Content {
 					if block.IsText() {
 						fmt.Print(block.Text)
 					}
 				}
-			case *claude.ResultMessage:
-				// Query complete, break to prompt for next input
-				break readLoop
 			}
 		}
+		if _, err := turn.Wait(); err != nil {
+			fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		}
 		fmt.Println()
 		fmt.Println()
 	}
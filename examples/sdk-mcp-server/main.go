@@ -0,0 +1,70 @@
+// Example: sdk-mcp-server
+// Expose Go functions to Claude as MCP tools without a separate server process.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/panbanda/claude-agent-sdk-go/claude"
+)
+
+func main() {
+	ctx := context.Background()
+
+	numberSchema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"a": map[string]any{"type": "number"},
+			"b": map[string]any{"type": "number"},
+		},
+		"required": []string{"a", "b"},
+	}
+
+	add := claude.NewMCPTool("add", "Add two numbers", numberSchema,
+		func(ctx context.Context, args map[string]any) (*claude.MCPToolResult, error) {
+			a, _ := args["a"].(float64)
+			b, _ := args["b"].(float64)
+			return claude.NewMCPTextResult(strconv.FormatFloat(a+b, 'f', -1, 64)), nil
+		},
+	)
+
+	multiply := claude.NewMCPTool("multiply", "Multiply two numbers", numberSchema,
+		func(ctx context.Context, args map[string]any) (*claude.MCPToolResult, error) {
+			a, _ := args["a"].(float64)
+			b, _ := args["b"].(float64)
+			return claude.NewMCPTextResult(strconv.FormatFloat(a*b, 'f', -1, 64)), nil
+		},
+	)
+
+	calculator := claude.NewSDKMCPServer("calculator", "1.0.0", add, multiply)
+
+	msgs, err := claude.Query(ctx,
+		"Use the calculator tools to compute (12 + 30) * 7.",
+		claude.WithMCPServers(calculator),
+		claude.WithAllowedTools("mcp__calculator__add", "mcp__calculator__multiply"),
+		claude.WithMaxTurns(5),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for msg := range msgs {
+		switch m := msg.(type) {
+		case *claude.AssistantMessage:
+			for _, block := range m.Content {
+				switch {
+				case block.IsText():
+					fmt.Print(block.Text)
+				case block.IsToolUse():
+					fmt.Printf("\n[Calling %s with %v]\n", block.ToolName, block.ToolInput)
+				}
+			}
+		case *claude.ResultMessage:
+			fmt.Printf("\n\nCost: $%.4f\n", m.TotalCostUSD)
+		}
+	}
+}
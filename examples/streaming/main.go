@@ -22,7 +22,14 @@ func main() {
 	for msg := range msgs {
 		switch m := msg.(type) {
 		case *claude.UserMessage:
-			fmt.Printf("[User] %s\n", m.Content)
+			for _, block := range m.Content {
+				switch {
+				case block.IsText():
+					fmt.Printf("[User] %s\n", block.Text)
+				case block.IsToolResult():
+					fmt.Printf("[Tool Output] %v\n", block.ToolResult)
+				}
+			}
 
 		case *claude.AssistantMessage:
 			for _, block := range m.Content {
@@ -38,6 +45,9 @@ func main() {
 				}
 			}
 
+		case *claude.SystemInitMessage:
+			fmt.Printf("[System] session %s using %s\n", m.SessionID, m.Model)
+
 		case *claude.SystemMessage:
 			fmt.Printf("[System] %s: %v\n", m.Subtype, m.Data)
 